@@ -12,6 +12,8 @@ import (
 
 	"github.com/redhat-cop/cert-operator/pkg/apis"
 	"github.com/redhat-cop/cert-operator/pkg/controller"
+	"github.com/redhat-cop/cert-operator/pkg/issuer"
+	"github.com/redhat-cop/cert-operator/pkg/notifier"
 
 	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
 	"github.com/operator-framework/operator-sdk/pkg/leader"
@@ -80,6 +82,10 @@ func main() {
 
 	// Load Config
 	conf := certconf.NewConfig()
+	if err := conf.General.Validate(); err != nil {
+		log.Error(err, "Invalid configuration")
+		os.Exit(1)
+	}
 
 	ctx := context.TODO()
 
@@ -114,8 +120,21 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Setup the issuers and notifications shared by all Controllers
+	issuers, err := issuer.NewResolver(mgr, conf)
+	if err != nil {
+		log.Error(err, "Failed to configure the provider")
+		os.Exit(1)
+	}
+
+	notifications, err := notifier.NewDispatcher(conf.Notifiers)
+	if err != nil {
+		log.Error(err, "Failed to configure the notifiers")
+		os.Exit(1)
+	}
+
 	// Setup all Controllers
-	if err := controller.AddToManager(mgr, conf); err != nil {
+	if err := controller.AddToManager(mgr, conf, issuers, notifications); err != nil {
 		log.Error(err, "")
 		os.Exit(1)
 	}
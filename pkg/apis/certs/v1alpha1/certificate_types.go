@@ -0,0 +1,124 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// CertificateSpec defines the desired state of Certificate
+// +k8s:openapi-gen=true
+type CertificateSpec struct {
+	// Hosts are the DNS names and IP addresses the certificate is issued for. The first
+	// one becomes the common name.
+	Hosts []string `json:"hosts"`
+	// SecretName is the Secret in the same namespace the certificate is written to
+	SecretName string `json:"secretName"`
+	// Format of the Secret, either PEM (the default) or PKCS12
+	Format string `json:"format,omitempty"`
+	// Provider is the kind of provider that issues the certificate. It defaults to the
+	// provider the operator, or the referenced issuer, is configured with.
+	Provider string `json:"provider,omitempty"`
+	// IssuerRef selects an Issuer or ClusterIssuer instead of the operator's provider
+	IssuerRef *IssuerReference `json:"issuerRef,omitempty"`
+	// Duration is the requested validity of the certificate
+	Duration *metav1.Duration `json:"duration,omitempty"`
+	// RenewBefore is how long before expiry the certificate is renewed
+	RenewBefore *metav1.Duration `json:"renewBefore,omitempty"`
+	// KeyAlgorithm is RSA (the default) or ECDSA
+	KeyAlgorithm string `json:"keyAlgorithm,omitempty"`
+	// KeySize is the RSA modulus length or the ECDSA curve size
+	KeySize int `json:"keySize,omitempty"`
+}
+
+// CertificateConditionType is the type of a CertificateCondition
+type CertificateConditionType string
+
+const (
+	// CertificateReady means a valid certificate is stored in the Secret
+	CertificateReady CertificateConditionType = "Ready"
+	// CertificateIssuing means a certificate has been requested and not issued yet
+	CertificateIssuing CertificateConditionType = "Issuing"
+)
+
+// CertificateCondition describes the state of a Certificate at a certain point
+// +k8s:openapi-gen=true
+type CertificateCondition struct {
+	Type               CertificateConditionType `json:"type"`
+	Status             corev1.ConditionStatus   `json:"status"`
+	LastTransitionTime metav1.Time              `json:"lastTransitionTime,omitempty"`
+	Reason             string                   `json:"reason,omitempty"`
+	Message            string                   `json:"message,omitempty"`
+}
+
+// CertificateStatus defines the observed state of Certificate
+// +k8s:openapi-gen=true
+type CertificateStatus struct {
+	Conditions []CertificateCondition `json:"conditions,omitempty"`
+	// ObservedGeneration is the generation of the spec the current certificate was issued for
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Serial is the hex encoded serial number of the current certificate
+	Serial    string       `json:"serial,omitempty"`
+	NotBefore *metav1.Time `json:"notBefore,omitempty"`
+	NotAfter  *metav1.Time `json:"notAfter,omitempty"`
+	// LastFailureTime and LastFailureMessage describe the last failed attempt to issue
+	LastFailureTime    *metav1.Time `json:"lastFailureTime,omitempty"`
+	LastFailureMessage string       `json:"lastFailureMessage,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// Certificate is the Schema for the certificates API
+// +k8s:openapi-gen=true
+// +kubebuilder:subresource:status
+type Certificate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CertificateSpec   `json:"spec,omitempty"`
+	Status CertificateStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// CertificateList contains a list of Certificate
+type CertificateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Certificate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Certificate{}, &CertificateList{})
+}
+
+// SetCondition adds or replaces the condition of the same type, keeping the transition
+// time when the status did not change
+func (s *CertificateStatus) SetCondition(condition CertificateCondition) {
+	for i, existing := range s.Conditions {
+		if existing.Type != condition.Type {
+			continue
+		}
+		if existing.Status == condition.Status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+		if condition.LastTransitionTime.IsZero() {
+			condition.LastTransitionTime = metav1.Now()
+		}
+		s.Conditions[i] = condition
+		return
+	}
+	if condition.LastTransitionTime.IsZero() {
+		condition.LastTransitionTime = metav1.Now()
+	}
+	s.Conditions = append(s.Conditions, condition)
+}
+
+// GetCondition returns the condition of the given type, or nil
+func (s *CertificateStatus) GetCondition(conditionType CertificateConditionType) *CertificateCondition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == conditionType {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// IssuerSpec describes a provider instance certificates can be issued by
+// +k8s:openapi-gen=true
+type IssuerSpec struct {
+	// Provider is the kind of provider: none, self-signed, ca, acme, vault or venafi
+	Provider string `json:"provider"`
+	// InsecureSkipVerify disables verification of the provider's TLS certificate
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+	// Timeout bounds every call to the provider
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// CredentialsSecretRef names a Secret holding the credentials of the provider. It is read
+	// from the namespace of an Issuer, or from the operator namespace for a ClusterIssuer.
+	CredentialsSecretRef *corev1.LocalObjectReference `json:"credentialsSecretRef,omitempty"`
+	// Duration is the validity of certificates that do not request their own
+	Duration *metav1.Duration `json:"duration,omitempty"`
+
+	CA     *CAIssuer     `json:"ca,omitempty"`
+	ACME   *ACMEIssuer   `json:"acme,omitempty"`
+	Vault  *VaultIssuer  `json:"vault,omitempty"`
+	Venafi *VenafiIssuer `json:"venafi,omitempty"`
+}
+
+// CAIssuer signs certificates with a CA kept in a Secret, which is generated if it does not exist
+// +k8s:openapi-gen=true
+type CAIssuer struct {
+	// SecretName is the Secret holding the CA, it defaults to <issuer name>-ca
+	SecretName   string           `json:"secretName,omitempty"`
+	CommonName   string           `json:"commonName,omitempty"`
+	Organization string           `json:"organization,omitempty"`
+	Validity     *metav1.Duration `json:"validity,omitempty"`
+}
+
+// ACMEIssuer orders certificates from an ACME directory
+// +k8s:openapi-gen=true
+type ACMEIssuer struct {
+	// Server is the directory URL
+	Server          string `json:"server"`
+	Email           string `json:"email,omitempty"`
+	SolverNamespace string `json:"solverNamespace,omitempty"`
+}
+
+// VaultIssuer requests certificates from a Vault PKI role. The credentials Secret holds `token`
+// for the token auth method, `jwt` for kubernetes, or `role-id` and `secret-id` for approle.
+// Only a ClusterIssuer may use kubernetes auth without a `jwt`, with the operator's own
+// service account token.
+// +k8s:openapi-gen=true
+type VaultIssuer struct {
+	Server     string `json:"server"`
+	Mount      string `json:"mount,omitempty"`
+	Role       string `json:"role"`
+	Mode       string `json:"mode,omitempty"`
+	AuthMethod string `json:"authMethod,omitempty"`
+	AuthPath   string `json:"authPath,omitempty"`
+	AuthRole   string `json:"authRole,omitempty"`
+}
+
+// VenafiIssuer requests certificates from Venafi TPP or Venafi Cloud. The credentials Secret
+// holds `user` and `password` for TPP, or `api-key` for Venafi Cloud.
+// +k8s:openapi-gen=true
+type VenafiIssuer struct {
+	Connector string `json:"connector,omitempty"`
+	URL       string `json:"url,omitempty"`
+	Zone      string `json:"zone"`
+}
+
+// IssuerStatus defines the observed state of Issuer
+// +k8s:openapi-gen=true
+type IssuerStatus struct {
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// Issuer is the Schema for the issuers API, it issues certificates in its own namespace
+// +k8s:openapi-gen=true
+// +kubebuilder:subresource:status
+type Issuer struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IssuerSpec   `json:"spec,omitempty"`
+	Status IssuerStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// IssuerList contains a list of Issuer
+type IssuerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Issuer `json:"items"`
+}
+
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ClusterIssuer is the Schema for the clusterissuers API, it issues certificates in every namespace
+// +k8s:openapi-gen=true
+// +kubebuilder:subresource:status
+type ClusterIssuer struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   IssuerSpec   `json:"spec,omitempty"`
+	Status IssuerStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ClusterIssuerList contains a list of ClusterIssuer
+type ClusterIssuerList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterIssuer `json:"items"`
+}
+
+const (
+	// IssuerKind and ClusterIssuerKind are the kinds an IssuerReference may point to
+	IssuerKind        = "Issuer"
+	ClusterIssuerKind = "ClusterIssuer"
+)
+
+// IssuerReference points to the Issuer or ClusterIssuer a certificate is issued by
+// +k8s:openapi-gen=true
+type IssuerReference struct {
+	Name string `json:"name"`
+	// Kind is Issuer (the default) or ClusterIssuer
+	Kind string `json:"kind,omitempty"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Issuer{}, &IssuerList{}, &ClusterIssuer{}, &ClusterIssuerList{})
+}
@@ -0,0 +1,636 @@
+// The ACME Provider
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	routev1 "github.com/openshift/api/route/v1"
+	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
+	"golang.org/x/crypto/acme"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	acmePollInterval    = 2 * time.Second
+	acmeSolverLabel     = "cert-operator.redhat-cop.io/acme-solver"
+	acmeOrderLabel      = "cert-operator.redhat-cop.io/acme-order"
+	acmeChallengePrefix = "/.well-known/acme-challenge/"
+)
+
+// ACMEConfig describes the ACME directory to order certificates from and how HTTP-01
+// challenges are answered. The certificate of the directory is verified against CAPath, or the
+// system roots when it is empty, unless InsecureSkipVerify is set.
+type ACMEConfig struct {
+	DirectoryURL       string `json:"directory-url"`
+	CAPath             string `json:"ca-path"`
+	InsecureSkipVerify bool   `json:"insecure-skip-verify"`
+	Email              string `json:"email"`
+	AccountSecret      string `json:"account-secret"`
+	SolverNamespace    string `json:"solver-namespace"`
+	SolverImage        string `json:"solver-image"`
+	SolverPort         int    `json:"solver-port"`
+	SolverDocRoot      string `json:"solver-docroot"`
+}
+
+// ACMEProvider orders certificates from an RFC 8555 directory. HTTP-01 challenges are solved
+// by serving the key authorization from a short-lived pod exposed through a Route on the
+// challenged host, all of which is removed once the authorization completes.
+type ACMEProvider struct {
+	client    client.Client
+	namespace string
+	config    ACMEConfig
+	// httpClient talks to the directory
+	httpClient *http.Client
+
+	mutex   sync.Mutex
+	account *acme.Client
+}
+
+func NewACMEProvider(cfg *rest.Config, scheme *runtime.Scheme, config ACMEConfig) (*ACMEProvider, error) {
+	// use a direct client, solver objects must be visible as soon as they are created
+	c, err := client.New(cfg, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, NewCertError("could not create kubernetes client: " + err.Error())
+	}
+
+	namespace, err := OperatorNamespace("")
+	if err != nil {
+		return nil, NewCertError("could not determine the ACME account namespace: " + err.Error())
+	}
+
+	httpClient, err := newHTTPClient(config.CAPath, config.InsecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ACMEProvider{
+		client:     c,
+		namespace:  namespace,
+		config:     config,
+		httpClient: httpClient,
+	}, nil
+}
+
+// Provision submits an order and drives it to completion within the deadline of ctx
+func (p *ACMEProvider) Provision(ctx context.Context, req CertificateRequest) (keypair KeyPair, certError error) {
+
+	pending, err := p.Submit(ctx, req)
+	if err != nil {
+		return KeyPair{}, err
+	}
+
+	for {
+		keyPair, err := p.Retrieve(ctx, pending)
+		if _, ok := err.(*ErrCertificatePending); !ok {
+			return keyPair, err
+		}
+
+		select {
+		case <-ctx.Done():
+			p.removeSolvers(pending.ID)
+			return KeyPair{}, NewCertError("ACME order was not completed: " + ctx.Err().Error())
+		case <-time.After(acmePollInterval):
+		}
+	}
+}
+
+// Submit creates an order and the solvers for the HTTP-01 challenges of its pending
+// authorizations without waiting for them. The order URL is the pickup ID.
+func (p *ACMEProvider) Submit(ctx context.Context, req CertificateRequest) (PendingRequest, error) {
+
+	if err := req.validate(); err != nil {
+		return PendingRequest{}, err
+	}
+
+	if req.IsCA {
+		return PendingRequest{}, NewCertError("ACME does not issue CA certificates")
+	}
+
+	if len(req.IPAddresses) > 0 || len(req.URIs) > 0 || len(req.EmailAddresses) > 0 {
+		return PendingRequest{}, NewCertError("ACME only issues certificates for DNS names")
+	}
+
+	c, err := p.register(ctx)
+	if err != nil {
+		return PendingRequest{}, err
+	}
+
+	order, err := c.AuthorizeOrder(ctx, acme.DomainIDs(req.DNSNames...))
+	if err != nil {
+		return PendingRequest{}, NewCertError("could not create ACME order: " + err.Error())
+	}
+
+	for _, authzURL := range order.AuthzURLs {
+		authz, err := c.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			p.removeSolvers(order.URI)
+			return PendingRequest{}, NewCertError("could not fetch ACME authorization: " + err.Error())
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+		if err := p.solve(ctx, c, order.URI, authz, req.Options[OptionNamespace]); err != nil {
+			p.removeSolvers(order.URI)
+			return PendingRequest{}, err
+		}
+	}
+
+	priv, err := generatePrivateKey(req.KeyAlgorithm, req.KeySize)
+	if err != nil {
+		p.removeSolvers(order.URI)
+		return PendingRequest{}, err
+	}
+	pemBlock, err := pemBlockForKey(priv)
+	if err != nil {
+		p.removeSolvers(order.URI)
+		return PendingRequest{}, err
+	}
+
+	return PendingRequest{
+		ID:  order.URI,
+		Key: pem.EncodeToMemory(pemBlock),
+	}, nil
+}
+
+// Retrieve moves a submitted order on: challenges are accepted once their solver is ready and
+// the order is finalized once the directory has validated them all. The solvers are removed
+// when the order is done.
+func (p *ACMEProvider) Retrieve(ctx context.Context, pending PendingRequest) (KeyPair, error) {
+	c, err := p.register(ctx)
+	if err != nil {
+		return KeyPair{}, err
+	}
+
+	order, err := c.GetOrder(ctx, pending.ID)
+	if err != nil {
+		return KeyPair{}, NewCertError("could not fetch ACME order: " + err.Error())
+	}
+
+	var der [][]byte
+	switch order.Status {
+	case acme.StatusPending:
+		if err := p.accept(ctx, c, order); err != nil {
+			p.removeSolvers(order.URI)
+			return KeyPair{}, err
+		}
+		return KeyPair{}, NewErrCertificatePending("ACME order " + order.URI + " has not been authorized yet")
+	case acme.StatusProcessing:
+		return KeyPair{}, NewErrCertificatePending("ACME order " + order.URI + " is being processed")
+	case acme.StatusReady:
+		p.removeSolvers(order.URI)
+		der, err = p.finalize(ctx, c, order, pending.Key)
+	case acme.StatusValid:
+		// finalized by an earlier call that did not get to collect the certificate
+		p.removeSolvers(order.URI)
+		der, err = c.FetchCert(ctx, order.CertURL, true)
+		if err != nil {
+			err = NewCertError("could not fetch ACME certificate: " + err.Error())
+		}
+	default:
+		p.removeSolvers(order.URI)
+		if order.Error != nil {
+			return KeyPair{}, NewCertError("ACME order was not authorized: " + order.Error.Error())
+		}
+		return KeyPair{}, NewCertError("ACME order " + order.URI + " is " + order.Status)
+	}
+	if err != nil {
+		return KeyPair{}, err
+	}
+
+	leaf, err := x509.ParseCertificate(der[0])
+	if err != nil {
+		return KeyPair{}, NewCertError("could not parse issued certificate: " + err.Error())
+	}
+
+	var chain []byte
+	for _, b := range der[1:] {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b})...)
+	}
+
+	return KeyPair{
+		Cert:   pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der[0]}),
+		Key:    pending.Key,
+		CA:     chain,
+		Expiry: leaf.NotAfter,
+	}, nil
+}
+
+// finalize submits a CSR for the identifiers of order signed with the pending key and returns
+// the issued chain
+func (p *ACMEProvider) finalize(ctx context.Context, c *acme.Client, order *acme.Order, key []byte) ([][]byte, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, NewErrPrivateKey("pending ACME request has no private key")
+	}
+	priv, err := parsePrivateKey(block.Bytes)
+	if err != nil {
+		return nil, NewErrPrivateKey("Failed to parse pending ACME key: " + err.Error())
+	}
+
+	var names []string
+	for _, id := range order.Identifiers {
+		names = append(names, id.Value)
+	}
+	csr, err := NewCertificateRequest(names...).csr(priv)
+	if err != nil {
+		return nil, NewCertError("could not create certificate request: " + err.Error())
+	}
+
+	der, _, err := c.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, NewCertError("could not finalize ACME order: " + err.Error())
+	}
+	return der, nil
+}
+
+// Deprovision revokes the certificate with the account that ordered it
+func (p *ACMEProvider) Deprovision(ctx context.Context, cert []byte) error {
+	leaf, err := ParseCertificate(cert)
+	if err != nil {
+		return err
+	}
+
+	c, err := p.register(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = c.RevokeCert(ctx, nil, leaf.Raw, acme.CRLReasonCessationOfOperation)
+	if acmeErr, ok := err.(*acme.Error); ok && acmeErr.ProblemType == "urn:ietf:params:acme:error:alreadyRevoked" {
+		return nil
+	}
+	if err != nil {
+		return NewCertError("could not revoke certificate: " + err.Error())
+	}
+	return nil
+}
+
+// register returns an ACME client for the operator's account, creating the account key and
+// registering it with the directory on first use
+func (p *ACMEProvider) register(ctx context.Context) (*acme.Client, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.account != nil {
+		return p.account, nil
+	}
+
+	key, err := p.accountKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &acme.Client{
+		Key:          key,
+		DirectoryURL: p.config.DirectoryURL,
+		HTTPClient:   p.httpClient,
+	}
+
+	account := &acme.Account{}
+	if len(p.config.Email) > 0 {
+		account.Contact = []string{"mailto:" + p.config.Email}
+	}
+	_, err = c.Register(ctx, account, acme.AcceptTOS)
+	if err != nil && err != acme.ErrAccountAlreadyExists {
+		return nil, NewCertError("could not register ACME account: " + err.Error())
+	}
+
+	p.account = c
+	return c, nil
+}
+
+// accountKey loads the ACME account key from its Secret, generating and storing one if needed
+func (p *ACMEProvider) accountKey(ctx context.Context) (*ecdsa.PrivateKey, error) {
+	name := types.NamespacedName{Namespace: p.namespace, Name: p.config.AccountSecret}
+	secret := &corev1.Secret{}
+	err := p.client.Get(ctx, name, secret)
+	if errors.IsNotFound(err) {
+		secret, err = p.createAccountKey(ctx)
+		if errors.IsAlreadyExists(err) {
+			// another controller registered first, use their key
+			secret = &corev1.Secret{}
+			err = p.client.Get(ctx, name, secret)
+		}
+	}
+	if err != nil {
+		return nil, NewCertError("could not load ACME account key: " + err.Error())
+	}
+
+	block, _ := pem.Decode(secret.Data["tls.key"])
+	if block == nil {
+		return nil, NewErrPrivateKey("ACME account secret " + p.config.AccountSecret + " has no private key")
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, NewErrPrivateKey("Failed to parse ACME account key: " + err.Error())
+	}
+	return key, nil
+}
+
+func (p *ACMEProvider) createAccountKey(ctx context.Context) (*corev1.Secret, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, NewErrPrivateKey("failed to generate ACME account key: " + err.Error())
+	}
+	pemBlock, err := pemBlockForKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      p.config.AccountSecret,
+			Namespace: p.namespace,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"tls.key": pem.EncodeToMemory(pemBlock),
+		},
+	}
+	return secret, p.client.Create(ctx, secret)
+}
+
+// solve creates the solver for the HTTP-01 challenge of a pending authorization of the order,
+// placing it in the given namespace or, when empty, in the namespace chosen by solverNamespace
+func (p *ACMEProvider) solve(ctx context.Context, c *acme.Client, orderURI string, authz *acme.Authorization, namespace string) error {
+	challenge := http01Challenge(authz)
+	if challenge == nil {
+		return NewCertError("no http-01 challenge offered for " + authz.Identifier.Value)
+	}
+
+	keyAuth, err := c.HTTP01ChallengeResponse(challenge.Token)
+	if err != nil {
+		return NewCertError("could not compute ACME key authorization: " + err.Error())
+	}
+
+	if len(namespace) == 0 {
+		namespace, err = p.solverNamespace(ctx, authz.Identifier.Value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return p.createSolver(ctx, p.newSolver(namespace, orderURI, authz.Identifier.Value, challenge.Token, keyAuth))
+}
+
+// accept tells the directory to validate the challenges of the pending authorizations of order
+// whose solver is ready. Challenges already accepted are left to the directory.
+func (p *ACMEProvider) accept(ctx context.Context, c *acme.Client, order *acme.Order) error {
+	for _, authzURL := range order.AuthzURLs {
+		authz, err := c.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			return NewCertError("could not fetch ACME authorization: " + err.Error())
+		}
+		if authz.Status != acme.StatusPending {
+			continue
+		}
+		challenge := http01Challenge(authz)
+		if challenge == nil || challenge.Status != acme.StatusPending {
+			continue
+		}
+
+		ready, err := p.solverReady(ctx, solverName(challenge.Token))
+		if err != nil {
+			return err
+		}
+		if !ready {
+			continue
+		}
+		if _, err := c.Accept(ctx, challenge); err != nil {
+			return NewCertError("could not accept ACME challenge: " + err.Error())
+		}
+	}
+	return nil
+}
+
+func http01Challenge(authz *acme.Authorization) *acme.Challenge {
+	for _, ch := range authz.Challenges {
+		if ch.Type == "http-01" {
+			return ch
+		}
+	}
+	return nil
+}
+
+// solverNamespace places the solver next to the Route that owns the host, so the router
+// admits the challenge path under the same namespace ownership
+func (p *ACMEProvider) solverNamespace(ctx context.Context, host string) (string, error) {
+	watchNamespace, err := k8sutil.GetWatchNamespace()
+	if err != nil {
+		return "", NewCertError("could not determine the watch namespace: " + err.Error())
+	}
+
+	routes := &routev1.RouteList{}
+	if err := p.client.List(ctx, &client.ListOptions{Namespace: watchNamespace}, routes); err != nil {
+		return "", NewCertError("could not list routes: " + err.Error())
+	}
+	for _, route := range routes.Items {
+		if route.Spec.Host == host {
+			return route.Namespace, nil
+		}
+	}
+
+	if len(p.config.SolverNamespace) > 0 {
+		return p.config.SolverNamespace, nil
+	}
+	return p.namespace, nil
+}
+
+type acmeSolver struct {
+	configMap *corev1.ConfigMap
+	pod       *corev1.Pod
+	service   *corev1.Service
+	route     *routev1.Route
+}
+
+// solverName returns the name of the solver objects for the challenge with token
+func solverName(token string) string {
+	sum := sha1.Sum([]byte(token))
+	return "cert-operator-acme-" + hex.EncodeToString(sum[:])[:10]
+}
+
+// orderLabel returns the value of the order label of the solvers of the order at orderURI
+func orderLabel(orderURI string) string {
+	sum := sha1.Sum([]byte(orderURI))
+	return hex.EncodeToString(sum[:])[:20]
+}
+
+func (p *ACMEProvider) newSolver(namespace string, orderURI string, host string, token string, keyAuth string) *acmeSolver {
+	name := solverName(token)
+	labels := map[string]string{
+		acmeSolverLabel: name,
+	}
+	meta := metav1.ObjectMeta{
+		Name:      name,
+		Namespace: namespace,
+		Labels: map[string]string{
+			acmeSolverLabel: name,
+			acmeOrderLabel:  orderLabel(orderURI),
+		},
+	}
+
+	return &acmeSolver{
+		configMap: &corev1.ConfigMap{
+			ObjectMeta: meta,
+			Data: map[string]string{
+				token: keyAuth,
+			},
+		},
+		pod: &corev1.Pod{
+			ObjectMeta: meta,
+			Spec: corev1.PodSpec{
+				RestartPolicy: corev1.RestartPolicyNever,
+				Containers: []corev1.Container{
+					{
+						Name:  "solver",
+						Image: p.config.SolverImage,
+						Ports: []corev1.ContainerPort{
+							{ContainerPort: int32(p.config.SolverPort)},
+						},
+						VolumeMounts: []corev1.VolumeMount{
+							{
+								Name:      "challenge",
+								MountPath: strings.TrimSuffix(p.config.SolverDocRoot, "/") + strings.TrimSuffix(acmeChallengePrefix, "/"),
+								ReadOnly:  true,
+							},
+						},
+					},
+				},
+				Volumes: []corev1.Volume{
+					{
+						Name: "challenge",
+						VolumeSource: corev1.VolumeSource{
+							ConfigMap: &corev1.ConfigMapVolumeSource{
+								LocalObjectReference: corev1.LocalObjectReference{Name: name},
+							},
+						},
+					},
+				},
+			},
+		},
+		service: &corev1.Service{
+			ObjectMeta: meta,
+			Spec: corev1.ServiceSpec{
+				Selector: labels,
+				Ports: []corev1.ServicePort{
+					{
+						Name:       "http",
+						Port:       int32(p.config.SolverPort),
+						TargetPort: intstr.FromInt(p.config.SolverPort),
+					},
+				},
+			},
+		},
+		route: &routev1.Route{
+			ObjectMeta: meta,
+			Spec: routev1.RouteSpec{
+				Host: host,
+				Path: acmeChallengePrefix + token,
+				To: routev1.RouteTargetReference{
+					Kind: "Service",
+					Name: name,
+				},
+				Port: &routev1.RoutePort{
+					TargetPort: intstr.FromString("http"),
+				},
+			},
+		},
+	}
+}
+
+// createSolver creates the solver objects
+func (p *ACMEProvider) createSolver(ctx context.Context, solver *acmeSolver) error {
+	for _, obj := range []runtime.Object{solver.configMap, solver.pod, solver.service, solver.route} {
+		if err := p.client.Create(ctx, obj); err != nil && !errors.IsAlreadyExists(err) {
+			return NewCertError("could not create ACME solver: " + err.Error())
+		}
+	}
+	return nil
+}
+
+// solverReady reports whether the pod of the solver with name is ready and its route admitted
+func (p *ACMEProvider) solverReady(ctx context.Context, name string) (bool, error) {
+	pods := &corev1.PodList{}
+	if err := p.client.List(ctx, client.MatchingLabels(map[string]string{acmeSolverLabel: name}), pods); err != nil {
+		return false, NewCertError("could not list ACME solvers: " + err.Error())
+	}
+	if len(pods.Items) == 0 {
+		return false, NewCertError("ACME solver " + name + " was removed")
+	}
+	pod := &pods.Items[0]
+	if !podReady(pod) {
+		return false, nil
+	}
+
+	route := &routev1.Route{}
+	err := p.client.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}, route)
+	if errors.IsNotFound(err) {
+		return false, NewCertError("ACME solver " + name + " was removed")
+	}
+	if err != nil {
+		return false, NewCertError("could not get ACME solver route: " + err.Error())
+	}
+	return routeAdmitted(route), nil
+}
+
+// removeSolvers deletes the solver objects of the order at orderURI. It does not take the
+// provisioning context, so that the objects are still removed when provisioning was cancelled.
+func (p *ACMEProvider) removeSolvers(orderURI string) {
+	selector := map[string]string{acmeOrderLabel: orderLabel(orderURI)}
+	lists := []runtime.Object{&routev1.RouteList{}, &corev1.ServiceList{}, &corev1.PodList{}, &corev1.ConfigMapList{}}
+	for _, list := range lists {
+		if err := p.client.List(context.TODO(), client.MatchingLabels(selector), list); err != nil {
+			log.Error(err, "Could not list ACME solvers", "Order", orderURI)
+			continue
+		}
+		items, err := apimeta.ExtractList(list)
+		if err != nil {
+			log.Error(err, "Could not list ACME solvers", "Order", orderURI)
+			continue
+		}
+		for _, obj := range items {
+			if err := p.client.Delete(context.TODO(), obj); err != nil && !errors.IsNotFound(err) {
+				log.Error(err, "Could not remove ACME solver", "Order", orderURI)
+			}
+		}
+	}
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func routeAdmitted(route *routev1.Route) bool {
+	for _, ingress := range route.Status.Ingress {
+		for _, condition := range ingress.Conditions {
+			if condition.Type == routev1.RouteAdmitted && condition.Status == corev1.ConditionTrue {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,237 @@
+package certs
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	routev1 "github.com/openshift/api/route/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// TestACMEProvision orders and revokes a certificate against a Pebble test server that accepts
+// every challenge. Both files below are part of the Pebble repository,
+// https://github.com/letsencrypt/pebble, not of this one; start it from a checkout of Pebble with
+//
+//	PEBBLE_VA_ALWAYS_VALID=1 pebble -config test/config/pebble-config.json
+//
+// The test is skipped unless PEBBLE_DIRECTORY_URL points at its directory,
+// https://localhost:14000/dir, and PEBBLE_CA_PATH at the CA its directory is served with,
+// test/certs/pebble.minica.pem in that checkout.
+func TestACMEProvision(t *testing.T) {
+	directory := os.Getenv("PEBBLE_DIRECTORY_URL")
+	caPath := os.Getenv("PEBBLE_CA_PATH")
+	if len(directory) == 0 || len(caPath) == 0 {
+		t.Skip("PEBBLE_DIRECTORY_URL or PEBBLE_CA_PATH is not set")
+	}
+
+	// setup
+	c, provider := newPebbleProvider(t, directory, caPath)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	go readySolvers(ctx, t, c)
+
+	// act
+	req := NewCertificateRequest("test.example.com")
+	req.Options[OptionNamespace] = "test"
+	keyPair, err := provider.Provision(ctx, req)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := ParseCertificate(keyPair.Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.VerifyHostname("test.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(keyPair.CA) == 0 {
+		t.Fatal("no chain returned")
+	}
+	if !keyPair.Expiry.Equal(leaf.NotAfter) {
+		t.Fatal("expiry does not match the certificate")
+	}
+
+	account := &corev1.Secret{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "cert-operator", Name: "cert-operator-acme-account"}, account); err != nil {
+		t.Fatal("account key was not stored: " + err.Error())
+	}
+
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, &client.ListOptions{Namespace: "test"}, pods); err != nil {
+		t.Fatal(err)
+	}
+	routes := &routev1.RouteList{}
+	if err := c.List(ctx, &client.ListOptions{Namespace: "test"}, routes); err != nil {
+		t.Fatal(err)
+	}
+	if len(pods.Items) > 0 || len(routes.Items) > 0 {
+		t.Fatal("solver was not removed")
+	}
+
+	if err := provider.Deprovision(ctx, keyPair.Cert); err != nil {
+		t.Fatal(err)
+	}
+	// revoking twice is not an error
+	if err := provider.Deprovision(ctx, keyPair.Cert); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestACMESubmitRetrieve(t *testing.T) {
+	directory := os.Getenv("PEBBLE_DIRECTORY_URL")
+	caPath := os.Getenv("PEBBLE_CA_PATH")
+	if len(directory) == 0 || len(caPath) == 0 {
+		t.Skip("PEBBLE_DIRECTORY_URL or PEBBLE_CA_PATH is not set")
+	}
+
+	// setup
+	c, provider := newPebbleProvider(t, directory, caPath)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	req := NewCertificateRequest("async.example.com")
+	req.Options[OptionNamespace] = "test"
+
+	// act
+	pending, err := provider.Submit(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, retrieveErr := provider.Retrieve(ctx, pending)
+
+	// assert
+	if _, ok := retrieveErr.(*ErrCertificatePending); !ok {
+		t.Fatalf("expected the order to be pending while the solver is not ready, got %v", retrieveErr)
+	}
+
+	pods := &corev1.PodList{}
+	if err := c.List(ctx, &client.ListOptions{Namespace: "test"}, pods); err != nil {
+		t.Fatal(err)
+	}
+	if len(pods.Items) != 1 {
+		t.Fatalf("expected 1 solver pod, got %d", len(pods.Items))
+	}
+
+	go readySolvers(ctx, t, c)
+	var keyPair KeyPair
+	for {
+		keyPair, err = provider.Retrieve(ctx, pending)
+		if _, ok := err.(*ErrCertificatePending); !ok {
+			break
+		}
+		time.Sleep(acmePollInterval)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := ParseCertificate(keyPair.Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.VerifyHostname("async.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if string(keyPair.Key) != string(pending.Key) {
+		t.Fatal("certificate was not issued for the submitted key")
+	}
+
+	if err := c.List(ctx, &client.ListOptions{Namespace: "test"}, pods); err != nil {
+		t.Fatal(err)
+	}
+	if len(pods.Items) > 0 {
+		t.Fatal("solver was not removed")
+	}
+}
+
+// newPebbleProvider returns an ACME provider for the Pebble directory, which is verified against
+// the CA at caPath, backed by a fake client
+func newPebbleProvider(t *testing.T, directory string, caPath string) (client.Client, *ACMEProvider) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := routev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	httpClient, err := newHTTPClient(caPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewFakeClientWithScheme(scheme)
+	return c, &ACMEProvider{
+		client:    c,
+		namespace: "cert-operator",
+		config: ACMEConfig{
+			DirectoryURL:  directory,
+			CAPath:        caPath,
+			Email:         "admin@example.com",
+			AccountSecret: "cert-operator-acme-account",
+			SolverImage:   "registry.access.redhat.com/ubi8/httpd-24",
+			SolverPort:    8080,
+			SolverDocRoot: "/var/www/html",
+		},
+		httpClient: httpClient,
+	}
+}
+
+// readySolvers marks solver pods ready and their routes admitted, as the kubelet and the router would
+func readySolvers(ctx context.Context, t *testing.T, c client.Client) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pods := &corev1.PodList{}
+		if err := c.List(ctx, &client.ListOptions{}, pods); err != nil {
+			t.Log(err)
+			continue
+		}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if podReady(pod) {
+				continue
+			}
+			pod.Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+			if err := c.Update(ctx, pod); err != nil {
+				t.Log(err)
+			}
+		}
+
+		routes := &routev1.RouteList{}
+		if err := c.List(ctx, &client.ListOptions{}, routes); err != nil {
+			t.Log(err)
+			continue
+		}
+		for i := range routes.Items {
+			route := &routes.Items[i]
+			if routeAdmitted(route) {
+				continue
+			}
+			route.Status.Ingress = []routev1.RouteIngress{{
+				Host:       route.Spec.Host,
+				Conditions: []routev1.RouteIngressCondition{{Type: routev1.RouteAdmitted, Status: corev1.ConditionTrue, LastTransitionTime: &metav1.Time{Time: time.Now()}}},
+			}}
+			if err := c.Update(ctx, route); err != nil {
+				t.Log(err)
+			}
+		}
+	}
+}
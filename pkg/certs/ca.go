@@ -0,0 +1,316 @@
+// The internal CA Provider
+package certs
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	caCertKey  = "tls.crt"
+	caKeyKey   = "tls.key"
+	caChainKey = "ca.crt"
+	caCRLKey   = "ca.crl"
+)
+
+// CAConfig describes where the internal CA key pair is stored and how it is bootstrapped
+type CAConfig struct {
+	SecretName      string `json:"secret-name"`
+	SecretNamespace string `json:"secret-namespace"`
+	CommonName      string `json:"common-name"`
+	Organization    string `json:"organization"`
+	Validity        string `json:"validity"`
+}
+
+// CAProvider signs leaf certificates with a root or intermediate key pair kept in a Secret.
+// If the Secret does not exist a new self-signed root is generated and stored in it, otherwise
+// the key pair found in the Secret is used, which allows importing an existing intermediate.
+type CAProvider struct {
+	client    kubernetes.Interface
+	namespace string
+	config    CAConfig
+}
+
+type certAuthority struct {
+	cert  *x509.Certificate
+	key   interface{}
+	chain []byte
+}
+
+func NewCAProvider(cfg *rest.Config, config CAConfig) (*CAProvider, error) {
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, NewCertError("could not create kubernetes client: " + err.Error())
+	}
+
+	namespace, err := OperatorNamespace(config.SecretNamespace)
+	if err != nil {
+		return nil, NewCertError("could not determine the CA secret namespace: " + err.Error())
+	}
+
+	return newCAProvider(client, namespace, config), nil
+}
+
+func newCAProvider(client kubernetes.Interface, namespace string, config CAConfig) *CAProvider {
+	return &CAProvider{
+		client:    client,
+		namespace: namespace,
+		config:    config,
+	}
+}
+
+func (p *CAProvider) Provision(ctx context.Context, req CertificateRequest) (keypair KeyPair, certError error) {
+
+	if err := req.validate(); err != nil {
+		return KeyPair{}, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return KeyPair{}, NewCertError("provider call abandoned: " + err.Error())
+	}
+
+	ca, err := p.loadCA()
+	if err != nil {
+		return KeyPair{}, err
+	}
+
+	return ca.sign(req)
+}
+
+// Deprovision adds the certificate to the CRL kept in ca.crl of the CA Secret. Certificates
+// signed by a CA that has since been replaced cannot be revoked and are skipped.
+func (p *CAProvider) Deprovision(ctx context.Context, cert []byte) error {
+	leaf, err := ParseCertificate(cert)
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return NewCertError("provider call abandoned: " + err.Error())
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, err := p.client.CoreV1().Secrets(p.namespace).Get(p.config.SecretName, metav1.GetOptions{})
+		if err != nil {
+			return NewCertError("could not load CA secret " + p.namespace + "/" + p.config.SecretName + ": " + err.Error())
+		}
+
+		ca, err := parseCA(secret)
+		if err != nil {
+			return err
+		}
+		if err := leaf.CheckSignatureFrom(ca.cert); err != nil {
+			log.Info("Certificate was not issued by the current CA, not revoking it", "SerialNumber", leaf.SerialNumber.Text(16))
+			return nil
+		}
+
+		crl, err := ca.revoke(secret.Data[caCRLKey], leaf)
+		if err != nil || crl == nil {
+			return err
+		}
+
+		secret.Data[caCRLKey] = crl
+		_, err = p.client.CoreV1().Secrets(p.namespace).Update(secret)
+		return err
+	})
+}
+
+// loadCA reads the CA key pair from its Secret, bootstrapping a new root when none exists yet
+func (p *CAProvider) loadCA() (*certAuthority, error) {
+	secret, err := p.client.CoreV1().Secrets(p.namespace).Get(p.config.SecretName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		secret, err = p.bootstrap()
+	}
+	if err != nil {
+		return nil, NewCertError("could not load CA secret " + p.namespace + "/" + p.config.SecretName + ": " + err.Error())
+	}
+
+	return parseCA(secret)
+}
+
+func (p *CAProvider) bootstrap() (*corev1.Secret, error) {
+	validity, err := time.ParseDuration(p.config.Validity)
+	if err != nil {
+		return nil, NewCertError("invalid CA validity: " + err.Error())
+	}
+
+	priv, err := generatePrivateKey(RSAKey, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	subjectKeyID, err := keyIdentifier(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	notBefore := time.Now()
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			CommonName:   p.config.CommonName,
+			Organization: []string{p.config.Organization},
+		},
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(validity),
+		SubjectKeyId:          subjectKeyID,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, publicKey(priv), priv)
+	if err != nil {
+		return nil, NewCertError("Failed to create CA certificate: " + err.Error())
+	}
+
+	pemBlock, err := pemBlockForKey(priv)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      p.config.SecretName,
+			Namespace: p.namespace,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			caCertKey: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes}),
+			caKeyKey:  pem.EncodeToMemory(pemBlock),
+		},
+	}
+
+	created, err := p.client.CoreV1().Secrets(p.namespace).Create(secret)
+	if errors.IsAlreadyExists(err) {
+		// another controller bootstrapped the CA first, use theirs
+		return p.client.CoreV1().Secrets(p.namespace).Get(p.config.SecretName, metav1.GetOptions{})
+	}
+	return created, err
+}
+
+// parseCA extracts the signing certificate and key from a CA Secret. The first certificate in
+// tls.crt signs the leaves, any further certificates there or in ca.crt complete the chain.
+func parseCA(secret *corev1.Secret) (*certAuthority, error) {
+	certBlock, _ := pem.Decode(secret.Data[caCertKey])
+	if certBlock == nil {
+		return nil, NewCertError("CA secret " + secret.Name + " has no certificate in " + caCertKey)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, NewCertError("Failed to parse CA certificate: " + err.Error())
+	}
+	if !cert.IsCA {
+		return nil, NewCertError("certificate in CA secret " + secret.Name + " is not a CA")
+	}
+
+	keyBlock, _ := pem.Decode(secret.Data[caKeyKey])
+	if keyBlock == nil {
+		return nil, NewCertError("CA secret " + secret.Name + " has no private key in " + caKeyKey)
+	}
+	key, err := parsePrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, NewErrPrivateKey("Failed to parse CA private key: " + err.Error())
+	}
+
+	chain := append([]byte{}, secret.Data[caCertKey]...)
+	chain = append(chain, secret.Data[caChainKey]...)
+
+	return &certAuthority{
+		cert:  cert,
+		key:   key,
+		chain: chain,
+	}, nil
+}
+
+func (ca *certAuthority) sign(req CertificateRequest) (KeyPair, error) {
+	priv, err := generatePrivateKey(req.KeyAlgorithm, req.KeySize)
+	if err != nil {
+		return KeyPair{}, err
+	}
+
+	template, err := req.template()
+	if err != nil {
+		return KeyPair{}, err
+	}
+	// a leaf cannot outlive its issuer
+	if template.NotAfter.After(ca.cert.NotAfter) {
+		template.NotAfter = ca.cert.NotAfter
+	}
+	template.AuthorityKeyId = ca.cert.SubjectKeyId
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, ca.cert, publicKey(priv), ca.key)
+	if err != nil {
+		return KeyPair{}, NewCertError("Failed to sign certificate: " + err.Error())
+	}
+
+	cert, key, err := encodeKeyPair(derBytes, priv)
+	if err != nil {
+		return KeyPair{}, err
+	}
+
+	return KeyPair{
+		Cert:   cert,
+		Key:    key,
+		CA:     ca.chain,
+		Expiry: template.NotAfter,
+	}, nil
+}
+
+// revoke returns the PEM encoded CRL with leaf added to the entries of crl, or nil if it is
+// already listed. The CRL is valid for as long as the CA, it is only reissued on revocation.
+func (ca *certAuthority) revoke(crl []byte, leaf *x509.Certificate) ([]byte, error) {
+	var revoked []pkix.RevokedCertificate
+	if block, _ := pem.Decode(crl); block != nil {
+		list, err := x509.ParseCRL(block.Bytes)
+		if err != nil {
+			return nil, NewCertError("Failed to parse CRL: " + err.Error())
+		}
+		revoked = list.TBSCertList.RevokedCertificates
+	}
+
+	for _, entry := range revoked {
+		if entry.SerialNumber.Cmp(leaf.SerialNumber) == 0 {
+			return nil, nil
+		}
+	}
+
+	now := time.Now()
+	revoked = append(revoked, pkix.RevokedCertificate{
+		SerialNumber:   leaf.SerialNumber,
+		RevocationTime: now,
+	})
+
+	derBytes, err := ca.cert.CreateCRL(rand.Reader, ca.key, revoked, now, ca.cert.NotAfter)
+	if err != nil {
+		return nil, NewCertError("Failed to create CRL: " + err.Error())
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: derBytes}), nil
+}
+
+// keyIdentifier derives a subject key identifier from the SHA-1 hash of the public key
+func keyIdentifier(priv interface{}) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey(priv))
+	if err != nil {
+		return nil, NewCertError("Failed to marshal public key: " + err.Error())
+	}
+	sum := sha1.Sum(der)
+	return sum[:], nil
+}
@@ -0,0 +1,69 @@
+package certs
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCAProvision(t *testing.T) {
+	// setup
+	client := fake.NewSimpleClientset()
+	config := CAConfig{
+		SecretName:   "cert-operator-ca",
+		CommonName:   "cert-operator CA",
+		Organization: "cert-operator",
+		Validity:     "87600h",
+	}
+	provider := newCAProvider(client, "cert-operator", config)
+
+	// act
+	req := NewCertificateRequest("test.example.com", "10.0.0.1")
+	req.Duration = time.Hour
+	keyPair, err := provider.Provision(context.TODO(), req)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.CoreV1().Secrets("cert-operator").Get("cert-operator-ca", metav1.GetOptions{}); err != nil {
+		t.Fatal("CA secret was not bootstrapped: " + err.Error())
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(keyPair.CA) {
+		t.Fatal("no CA chain returned")
+	}
+
+	block, _ := pem.Decode(keyPair.Cert)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: "test.example.com", Roots: roots}); err != nil {
+		t.Fatal("leaf does not verify against the CA: " + err.Error())
+	}
+
+	if len(cert.IPAddresses) != 1 || cert.IPAddresses[0].String() != "10.0.0.1" {
+		t.Fatal("invalid IP SANs")
+	}
+
+	// a second provider must reuse the stored CA instead of generating a new one
+	req = NewCertificateRequest("other.example.com")
+	req.Duration = time.Hour
+	other, err := newCAProvider(client, "cert-operator", config).Provision(context.TODO(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(other.CA) != string(keyPair.CA) {
+		t.Fatal("CA was regenerated")
+	}
+}
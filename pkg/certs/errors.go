@@ -59,3 +59,18 @@ func NewErrFileWriteFail(message string) *ErrFileWriteFail {
 func (e *ErrFileWriteFail) Error() string {
 	return e.message
 }
+
+// Define ErrCertificatePending
+type ErrCertificatePending struct {
+	message string
+}
+
+func NewErrCertificatePending(message string) *ErrCertificatePending {
+	return &ErrCertificatePending{
+		message: message,
+	}
+}
+
+func (e *ErrCertificatePending) Error() string {
+	return e.message
+}
@@ -1,29 +1,95 @@
 package certs
 
 import (
+	"context"
 	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"fmt"
+	"math/big"
 	"time"
+
+	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
 )
 
+var log = logf.Log.WithName("certs")
+
+// Provider issues certificates. Deprovision revokes a PEM encoded certificate issued earlier,
+// providers that have no means of revocation do nothing.
 type Provider interface {
-	Provision(host string, validFrom string, validFor time.Duration, isCA bool, rsaBits int, ecdsaCurve string, ssl string) (KeyPair, error)
-	Deprovision(host string) error
+	Provision(ctx context.Context, req CertificateRequest) (KeyPair, error)
+	Deprovision(ctx context.Context, cert []byte) error
+}
+
+// AsyncProvider is implemented by providers whose CA may approve requests out of band.
+// Submit sends the request and returns as soon as the CA has accepted it; Retrieve collects
+// the certificate later and returns an ErrCertificatePending while it has not been issued.
+type AsyncProvider interface {
+	Provider
+	Submit(ctx context.Context, req CertificateRequest) (PendingRequest, error)
+	Retrieve(ctx context.Context, pending PendingRequest) (KeyPair, error)
+}
+
+// PendingRequest identifies a submitted request. ID is the CA's pickup ID; Key is the PEM
+// private key generated for the request, which must be kept secret until it is collected.
+type PendingRequest struct {
+	ID  string
+	Key []byte
 }
 
 type ProviderConfig struct {
-	Kind string `json:"kind"`
-	Ssl string  `json:"ssl"`
+	Kind    string       `json:"kind"`
+	Timeout string       `json:"timeout"`
+	CA      CAConfig     `json:"ca"`
+	ACME    ACMEConfig   `json:"acme"`
+	Vault   VaultConfig  `json:"vault"`
+	Venafi  VenafiConfig `json:"venafi"`
 }
 
 type KeyPair struct {
 	Cert   []byte
 	Key    []byte
+	CA     []byte
 	Expiry time.Time
 }
 
+// Leaf parses the issued certificate
+func (k KeyPair) Leaf() (*x509.Certificate, error) {
+	return ParseCertificate(k.Cert)
+}
+
+// ParseCertificate parses the first certificate of a PEM encoded chain
+func ParseCertificate(cert []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(cert)
+	if block == nil {
+		return nil, NewCertError("certificate is not PEM encoded")
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, NewCertError("could not parse certificate: " + err.Error())
+	}
+	return leaf, nil
+}
+
+// ChainToDER returns the DER bytes of every certificate in a PEM encoded chain
+func ChainToDER(chain []byte) [][]byte {
+	certs := [][]byte{}
+	for {
+		var block *pem.Block
+		block, chain = pem.Decode(chain)
+		if block == nil {
+			return certs
+		}
+		if block.Type == "CERTIFICATE" {
+			certs = append(certs, block.Bytes)
+		}
+	}
+}
+
 // Shared functions
 func publicKey(priv interface{}) interface{} {
 	switch k := priv.(type) {
@@ -50,3 +116,73 @@ func pemBlockForKey(priv interface{}) (*pem.Block, error) {
 		return nil, NewCertError("Ran out of possible options for PEM Block")
 	}
 }
+
+func generatePrivateKey(algorithm KeyAlgorithm, size int) (interface{}, error) {
+	var priv interface{}
+	var err error
+	switch algorithm {
+	case RSAKey, "":
+		if size == 0 {
+			size = 2048
+		}
+		priv, err = rsa.GenerateKey(rand.Reader, size)
+	case ECDSAKey:
+		curve, curveErr := ellipticCurve(size)
+		if curveErr != nil {
+			return nil, curveErr
+		}
+		priv, err = ecdsa.GenerateKey(curve, rand.Reader)
+	default:
+		return nil, NewCertError("Unrecognized key algorithm:" + string(algorithm))
+	}
+	if err != nil {
+		return nil, NewErrPrivateKey("failed to generate private key: " + err.Error())
+	}
+	return priv, nil
+}
+
+func ellipticCurve(size int) (elliptic.Curve, error) {
+	switch size {
+	case 224:
+		return elliptic.P224(), nil
+	case 256, 0:
+		return elliptic.P256(), nil
+	case 384:
+		return elliptic.P384(), nil
+	case 521:
+		return elliptic.P521(), nil
+	default:
+		return nil, NewCertError(fmt.Sprintf("Unrecognized elliptic curve size: %d", size))
+	}
+}
+
+// encodeKeyPair PEM encodes a certificate and its private key
+func encodeKeyPair(derBytes []byte, priv interface{}) (cert []byte, key []byte, err error) {
+	pemBlock, err := pemBlockForKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes}), pem.EncodeToMemory(pemBlock), nil
+}
+
+func newSerialNumber() (*big.Int, error) {
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return nil, NewCertError("failed to generate serial number: " + err.Error())
+	}
+	return serialNumber, nil
+}
+
+// OperatorNamespace returns the configured namespace, or the one the operator runs in
+func OperatorNamespace(configured string) (string, error) {
+	if len(configured) > 0 {
+		return configured, nil
+	}
+	namespace, err := k8sutil.GetOperatorNamespace()
+	if err != nil {
+		// not running in a cluster, fall back to the watched namespace
+		return k8sutil.GetWatchNamespace()
+	}
+	return namespace, nil
+}
@@ -2,13 +2,14 @@
 package certs
 
 import (
+	"context"
 	"time"
 )
 
 type NoneProvider struct {
 }
 
-func (p *NoneProvider) Provision(host string, validFrom string, validFor time.Duration, isCA bool, rsaBits int, ecdsaCurve string, ssl string) (keypair KeyPair, certError error) {
+func (p *NoneProvider) Provision(ctx context.Context, req CertificateRequest) (keypair KeyPair, certError error) {
 	return KeyPair{
 		Cert:   []byte{},
 		Key:    []byte{},
@@ -16,6 +17,6 @@ func (p *NoneProvider) Provision(host string, validFrom string, validFor time.Du
 	}, nil
 }
 
-func (p *NoneProvider) Deprovision(host string) error {
+func (p *NoneProvider) Deprovision(ctx context.Context, cert []byte) error {
 	return nil
 }
@@ -0,0 +1,156 @@
+package certs
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type KeyAlgorithm string
+
+const (
+	RSAKey   KeyAlgorithm = "RSA"
+	ECDSAKey KeyAlgorithm = "ECDSA"
+
+	// OptionNamespace carries the namespace of the object the certificate is issued for
+	OptionNamespace = "namespace"
+)
+
+// CertificateRequest describes the certificate a Provider is asked to issue.
+// KeySize is the RSA modulus length or the ECDSA curve size (224, 256, 384 or 521).
+// A zero NotBefore means the certificate is valid from the time it is issued.
+// Options holds provider specific settings that have no place in the X.509 fields.
+type CertificateRequest struct {
+	Subject        pkix.Name
+	DNSNames       []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+	EmailAddresses []string
+
+	KeyAlgorithm KeyAlgorithm
+	KeySize      int
+	KeyUsage     x509.KeyUsage
+	ExtKeyUsage  []x509.ExtKeyUsage
+
+	NotBefore time.Time
+	Duration  time.Duration
+	IsCA      bool
+
+	Options map[string]string
+}
+
+// NewCertificateRequest returns a request for a 2048 bit RSA server certificate covering
+// the given hosts, which may be DNS names or IP addresses
+func NewCertificateRequest(hosts ...string) CertificateRequest {
+	req := CertificateRequest{
+		KeyAlgorithm: RSAKey,
+		KeySize:      2048,
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		Options:      map[string]string{},
+	}
+	for _, h := range hosts {
+		h = strings.TrimSpace(h)
+		if len(h) == 0 {
+			continue
+		}
+		if ip := net.ParseIP(h); ip != nil {
+			req.IPAddresses = append(req.IPAddresses, ip)
+		} else {
+			req.DNSNames = append(req.DNSNames, h)
+		}
+	}
+	return req
+}
+
+// CommonName returns the subject common name, defaulting to the first SAN
+func (r CertificateRequest) CommonName() string {
+	if len(r.Subject.CommonName) > 0 {
+		return r.Subject.CommonName
+	}
+	if len(r.DNSNames) > 0 {
+		return r.DNSNames[0]
+	}
+	if len(r.IPAddresses) > 0 {
+		return r.IPAddresses[0].String()
+	}
+	return ""
+}
+
+// Hosts returns the DNS and IP SANs of the request as strings
+func (r CertificateRequest) Hosts() []string {
+	hosts := append([]string{}, r.DNSNames...)
+	for _, ip := range r.IPAddresses {
+		hosts = append(hosts, ip.String())
+	}
+	return hosts
+}
+
+func (r CertificateRequest) validate() error {
+	if len(r.CommonName()) == 0 {
+		return NewErrBadHost("host cannot be empty")
+	}
+	return nil
+}
+
+func (r CertificateRequest) validity() (notBefore time.Time, notAfter time.Time) {
+	notBefore = r.NotBefore
+	if notBefore.IsZero() {
+		notBefore = time.Now()
+	}
+	return notBefore, notBefore.Add(r.Duration)
+}
+
+// template returns the X.509 template for the request
+func (r CertificateRequest) template() (*x509.Certificate, error) {
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	notBefore, notAfter := r.validity()
+
+	subject := r.Subject
+	subject.CommonName = r.CommonName()
+
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject:      subject,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+
+		DNSNames:       r.DNSNames,
+		IPAddresses:    r.IPAddresses,
+		URIs:           r.URIs,
+		EmailAddresses: r.EmailAddresses,
+
+		KeyUsage:              r.KeyUsage,
+		ExtKeyUsage:           r.ExtKeyUsage,
+		BasicConstraintsValid: true,
+	}
+
+	if r.IsCA {
+		template.IsCA = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+
+	return template, nil
+}
+
+// csr returns a DER encoded certificate signing request for the request signed by priv
+func (r CertificateRequest) csr(priv interface{}) ([]byte, error) {
+	subject := r.Subject
+	subject.CommonName = r.CommonName()
+
+	return x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:        subject,
+		DNSNames:       r.DNSNames,
+		IPAddresses:    r.IPAddresses,
+		URIs:           r.URIs,
+		EmailAddresses: r.EmailAddresses,
+	}, priv)
+}
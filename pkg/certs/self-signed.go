@@ -1,116 +1,47 @@
 package certs
 
 import (
-	"crypto/ecdsa"
-	"crypto/elliptic"
+	"context"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
-	"crypto/x509/pkix"
-	"encoding/pem"
-	"math/big"
-	"net"
-	"strings"
-	"time"
 )
 
 type SelfSignedProvider struct {
 }
 
-func (p *SelfSignedProvider) Provision(host string, validFrom string, validFor time.Duration, isCA bool, rsaBits int, ecdsaCurve string, ssl string) (keypair KeyPair, certError error) {
+func (p *SelfSignedProvider) Provision(ctx context.Context, req CertificateRequest) (keypair KeyPair, certError error) {
 
-	if len(host) == 0 {
-		return KeyPair{}, NewErrBadHost("host cannot be empty")
+	if err := req.validate(); err != nil {
+		return KeyPair{}, err
 	}
 
-	var priv interface{}
-	var err error
-	switch ecdsaCurve {
-	case "":
-		priv, err = rsa.GenerateKey(rand.Reader, rsaBits)
-	case "P224":
-		priv, err = ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
-	case "P256":
-		priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	case "P384":
-		priv, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
-	case "P521":
-		priv, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
-	default:
-		return KeyPair{}, NewCertError("Unrecognized elliptic curve:" + ecdsaCurve)
-	}
+	priv, err := generatePrivateKey(req.KeyAlgorithm, req.KeySize)
 	if err != nil {
-		return KeyPair{}, NewErrPrivateKey("failed to generate private key: " + err.Error())
-	}
-
-	var notBefore time.Time
-	if len(validFrom) == 0 {
-		notBefore = time.Now()
-	} else {
-		notBefore, err = time.Parse("Jan 2 15:04:05 2006", validFrom)
-		if err != nil {
-			return KeyPair{}, NewCertError("Failed to parse creation date: " + err.Error())
-		}
+		return KeyPair{}, err
 	}
 
-	notAfter := notBefore.Add(validFor)
-
-	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	template, err := req.template()
 	if err != nil {
-		return KeyPair{}, NewCertError("failed to generate serial number: " + err.Error())
-	}
-
-	template := x509.Certificate{
-		SerialNumber: serialNumber,
-		Subject: pkix.Name{
-			Organization: []string{"Acme Co"},
-		},
-		NotBefore: notBefore,
-		NotAfter:  notAfter,
-
-		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		BasicConstraintsValid: true,
-	}
-
-	hosts := strings.Split(host, ",")
-	for _, h := range hosts {
-		if ip := net.ParseIP(h); ip != nil {
-			template.IPAddresses = append(template.IPAddresses, ip)
-		} else {
-			template.DNSNames = append(template.DNSNames, h)
-		}
-	}
-
-	if isCA {
-		template.IsCA = true
-		template.KeyUsage |= x509.KeyUsageCertSign
+		return KeyPair{}, err
 	}
 
-	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, publicKey(priv), priv)
+	derBytes, err := x509.CreateCertificate(rand.Reader, template, template, publicKey(priv), priv)
 	if err != nil {
 		return KeyPair{}, NewCertError("Failed to create certificate: " + err.Error())
 	}
 
-	var cert, key []byte
-
-	cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
-
-	pemBlock, err := pemBlockForKey(priv)
+	cert, key, err := encodeKeyPair(derBytes, priv)
 	if err != nil {
 		return KeyPair{}, err
 	}
 
-	key = pem.EncodeToMemory(pemBlock)
-
 	return KeyPair{
 		Cert:   cert,
 		Key:    key,
-		Expiry: notAfter,
+		Expiry: template.NotAfter,
 	}, nil
 }
 
-func (p *SelfSignedProvider) Deprovision(host string) error {
+func (p *SelfSignedProvider) Deprovision(ctx context.Context, cert []byte) error {
 	return nil
 }
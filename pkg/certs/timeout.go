@@ -0,0 +1,103 @@
+package certs
+
+import (
+	"context"
+	"time"
+)
+
+// DefaultTimeout returns how long a single call to a provider of the given kind may take
+// when no timeout is configured. CAs that validate or approve requests get longer.
+func DefaultTimeout(kind string) time.Duration {
+	switch kind {
+	case "acme":
+		return 5 * time.Minute
+	case "venafi":
+		return 3 * time.Minute
+	default:
+		return 30 * time.Second
+	}
+}
+
+// CallTimeout returns the configured deadline for a single provider call, falling back to
+// the default of the provider kind
+func (c ProviderConfig) CallTimeout() (time.Duration, error) {
+	if len(c.Timeout) == 0 {
+		return DefaultTimeout(c.Kind), nil
+	}
+	timeout, err := time.ParseDuration(c.Timeout)
+	if err != nil {
+		return 0, NewCertError("invalid provider timeout: " + err.Error())
+	}
+	return timeout, nil
+}
+
+type timeoutProvider struct {
+	provider Provider
+	timeout  time.Duration
+}
+
+type timeoutAsyncProvider struct {
+	timeoutProvider
+	async AsyncProvider
+}
+
+// WithTimeout bounds every call to provider by timeout on top of any deadline the caller sets.
+// The returned Provider is an AsyncProvider if provider is one.
+func WithTimeout(provider Provider, timeout time.Duration) Provider {
+	p := timeoutProvider{
+		provider: provider,
+		timeout:  timeout,
+	}
+	if async, ok := provider.(AsyncProvider); ok {
+		return &timeoutAsyncProvider{timeoutProvider: p, async: async}
+	}
+	return &p
+}
+
+func (p *timeoutProvider) Provision(ctx context.Context, req CertificateRequest) (KeyPair, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	return p.provider.Provision(ctx, req)
+}
+
+func (p *timeoutProvider) Deprovision(ctx context.Context, cert []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	return p.provider.Deprovision(ctx, cert)
+}
+
+func (p *timeoutAsyncProvider) Submit(ctx context.Context, req CertificateRequest) (PendingRequest, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	return p.async.Submit(ctx, req)
+}
+
+func (p *timeoutAsyncProvider) Retrieve(ctx context.Context, pending PendingRequest) (KeyPair, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	return p.async.Retrieve(ctx, pending)
+}
+
+// runWithContext runs a blocking call that cannot be cancelled itself, returning the
+// context's error as soon as it is done instead of waiting for the call to finish
+func runWithContext(ctx context.Context, f func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return NewCertError("provider call abandoned: " + ctx.Err().Error())
+	}
+}
+
+// remaining returns the time left until the context's deadline, or fallback if it has none
+func remaining(ctx context.Context, fallback time.Duration) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		return time.Until(deadline)
+	}
+	return fallback
+}
@@ -0,0 +1,97 @@
+package certs
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingProvider never returns on its own. Provision waits for its context like a provider
+// with a context aware client; Submit blocks in a call that cannot be cancelled, like vcert.
+type blockingProvider struct {
+	release chan struct{}
+}
+
+func (p *blockingProvider) Provision(ctx context.Context, req CertificateRequest) (KeyPair, error) {
+	<-ctx.Done()
+	return KeyPair{}, ctx.Err()
+}
+
+func (p *blockingProvider) Deprovision(ctx context.Context, cert []byte) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (p *blockingProvider) Submit(ctx context.Context, req CertificateRequest) (PendingRequest, error) {
+	err := runWithContext(ctx, func() error {
+		<-p.release
+		return nil
+	})
+	return PendingRequest{}, err
+}
+
+func (p *blockingProvider) Retrieve(ctx context.Context, pending PendingRequest) (KeyPair, error) {
+	return KeyPair{}, NewErrCertificatePending("not yet")
+}
+
+func TestWithTimeoutDeadline(t *testing.T) {
+	// setup
+	blocking := &blockingProvider{release: make(chan struct{})}
+	defer close(blocking.release)
+	provider := WithTimeout(blocking, 50*time.Millisecond)
+
+	// act
+	start := time.Now()
+	_, err := provider.Provision(context.Background(), NewCertificateRequest("test.example.com"))
+
+	// assert
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("call took %s", elapsed)
+	}
+	if err := provider.Deprovision(context.Background(), nil); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestWithTimeoutAbandonsBlockingCall(t *testing.T) {
+	// setup
+	blocking := &blockingProvider{release: make(chan struct{})}
+	defer close(blocking.release)
+	provider, ok := WithTimeout(blocking, 50*time.Millisecond).(AsyncProvider)
+	if !ok {
+		t.Fatal("an AsyncProvider lost its Submit and Retrieve")
+	}
+
+	// act
+	start := time.Now()
+	_, err := provider.Submit(context.Background(), NewCertificateRequest("test.example.com"))
+
+	// assert
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Fatalf("expected the call to be abandoned at the deadline, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("call took %s", elapsed)
+	}
+}
+
+func TestWithTimeoutCancellation(t *testing.T) {
+	// setup
+	blocking := &blockingProvider{release: make(chan struct{})}
+	defer close(blocking.release)
+	provider := WithTimeout(blocking, time.Hour).(AsyncProvider)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	// act
+	_, err := provider.Submit(ctx, NewCertificateRequest("test.example.com"))
+
+	// assert
+	if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("expected the call to be abandoned on cancellation, got %v", err)
+	}
+}
@@ -0,0 +1,31 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+)
+
+// newHTTPClient returns an HTTP client of its own for a provider, so the TLS settings of one
+// provider never reach another or the rest of the operator. The certificate of the server is
+// verified against the PEM bundle at caPath, or the system roots when it is empty, unless
+// insecure is set.
+func newHTTPClient(caPath string, insecure bool) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecure}
+	if len(caPath) > 0 {
+		bundle, err := ioutil.ReadFile(caPath)
+		if err != nil {
+			return nil, NewCertError("could not read CA bundle " + caPath + ": " + err.Error())
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(bundle) {
+			return nil, NewCertError("no certificates found in CA bundle " + caPath)
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,342 @@
+// The Vault PKI Provider
+package certs
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// VaultConfig describes the PKI secrets engine role certificates are requested from. The
+// certificate of Vault is verified against CAPath, or the system roots when it is empty,
+// unless InsecureSkipVerify is set.
+type VaultConfig struct {
+	Address            string          `json:"address"`
+	Mount              string          `json:"mount"`
+	Role               string          `json:"role"`
+	Mode               string          `json:"mode"`
+	CAPath             string          `json:"ca-path"`
+	InsecureSkipVerify bool            `json:"insecure-skip-verify"`
+	Auth               VaultAuthConfig `json:"auth"`
+}
+
+// VaultAuthConfig selects how the operator logs in to Vault. Method is one of `token`,
+// `kubernetes` or `approle`; Path is the mount of the auth method. Kubernetes auth sends JWT,
+// or else the service account token read from TokenPath.
+type VaultAuthConfig struct {
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Token     string `json:"token"`
+	Role      string `json:"role"`
+	JWT       string `json:"jwt"`
+	TokenPath string `json:"token-path"`
+	RoleID    string `json:"role-id"`
+	SecretID  string `json:"secret-id"`
+}
+
+// VaultProvider requests certificates from a Vault PKI role. In `issue` mode Vault generates
+// the private key, in `sign` mode the key is generated locally and only a CSR is sent.
+type VaultProvider struct {
+	config VaultConfig
+
+	mutex     sync.Mutex
+	client    *api.Client
+	renewAt   time.Time
+	renewable bool
+}
+
+func NewVaultProvider(config VaultConfig) (*VaultProvider, error) {
+	switch config.Mode {
+	case "issue", "sign":
+	default:
+		return nil, NewCertError("Unrecognized Vault mode: " + config.Mode)
+	}
+	switch config.Auth.Method {
+	case "token", "kubernetes", "approle":
+	default:
+		return nil, NewCertError("Unrecognized Vault auth method: " + config.Auth.Method)
+	}
+
+	return &VaultProvider{
+		config: config,
+	}, nil
+}
+
+func (p *VaultProvider) Provision(ctx context.Context, req CertificateRequest) (keypair KeyPair, certError error) {
+
+	if err := req.validate(); err != nil {
+		return KeyPair{}, err
+	}
+
+	if req.IsCA {
+		return KeyPair{}, NewCertError("Vault PKI roles do not issue CA certificates")
+	}
+
+	client, err := p.login(ctx)
+	if err != nil {
+		return KeyPair{}, err
+	}
+
+	var ipSANs, uriSANs []string
+	for _, ip := range req.IPAddresses {
+		ipSANs = append(ipSANs, ip.String())
+	}
+	for _, uri := range req.URIs {
+		uriSANs = append(uriSANs, uri.String())
+	}
+
+	data := map[string]interface{}{
+		"common_name": req.CommonName(),
+		"alt_names":   strings.Join(append(append([]string{}, req.DNSNames...), req.EmailAddresses...), ","),
+		"ip_sans":     strings.Join(ipSANs, ","),
+		"uri_sans":    strings.Join(uriSANs, ","),
+		"ttl":         req.Duration.String(),
+	}
+
+	var key []byte
+	if p.config.Mode == "sign" {
+		priv, err := generatePrivateKey(req.KeyAlgorithm, req.KeySize)
+		if err != nil {
+			return KeyPair{}, err
+		}
+
+		csr, err := req.csr(priv)
+		if err != nil {
+			return KeyPair{}, NewCertError("could not create certificate request: " + err.Error())
+		}
+		data["csr"] = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}))
+
+		pemBlock, err := pemBlockForKey(priv)
+		if err != nil {
+			return KeyPair{}, err
+		}
+		key = pem.EncodeToMemory(pemBlock)
+	} else {
+		switch req.KeyAlgorithm {
+		case RSAKey, "":
+			data["key_type"] = "rsa"
+		case ECDSAKey:
+			data["key_type"] = "ec"
+		default:
+			return KeyPair{}, NewCertError("Unrecognized key algorithm:" + string(req.KeyAlgorithm))
+		}
+		if req.KeySize > 0 {
+			data["key_bits"] = req.KeySize
+		}
+	}
+
+	path := strings.Trim(p.config.Mount, "/") + "/" + p.config.Mode + "/" + p.config.Role
+	secret, err := write(ctx, client, path, data)
+	if err != nil {
+		p.logout(client)
+		return KeyPair{}, NewCertError("could not request certificate from Vault: " + err.Error())
+	}
+	if secret == nil || secret.Data == nil {
+		return KeyPair{}, NewCertError("Vault returned no certificate for " + path)
+	}
+
+	cert, _ := secret.Data["certificate"].(string)
+	if p.config.Mode == "issue" {
+		privateKey, _ := secret.Data["private_key"].(string)
+		key = []byte(privateKey)
+	}
+
+	block, _ := pem.Decode([]byte(cert))
+	if block == nil {
+		return KeyPair{}, NewCertError("Vault returned an invalid certificate")
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return KeyPair{}, NewCertError("could not parse issued certificate: " + err.Error())
+	}
+
+	return KeyPair{
+		Cert:   []byte(cert),
+		Key:    key,
+		CA:     vaultChain(secret.Data),
+		Expiry: leaf.NotAfter,
+	}, nil
+}
+
+// Deprovision revokes the certificate by its serial number
+func (p *VaultProvider) Deprovision(ctx context.Context, cert []byte) error {
+	leaf, err := ParseCertificate(cert)
+	if err != nil {
+		return err
+	}
+
+	client, err := p.login(ctx)
+	if err != nil {
+		return err
+	}
+
+	path := strings.Trim(p.config.Mount, "/") + "/revoke"
+	_, err = write(ctx, client, path, map[string]interface{}{
+		"serial_number": vaultSerial(leaf.SerialNumber),
+	})
+	if err != nil {
+		p.logout(client)
+		return NewCertError("could not revoke certificate in Vault: " + err.Error())
+	}
+	return nil
+}
+
+// login returns a Vault client holding a token for the configured auth method. The token is
+// kept for later calls and renewed once two thirds of its lease have passed, or replaced by a
+// new login when it cannot be renewed.
+func (p *VaultProvider) login(ctx context.Context) (*api.Client, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.client != nil && (p.renewAt.IsZero() || time.Now().Before(p.renewAt)) {
+		return p.client, nil
+	}
+
+	if p.client != nil && p.renewable {
+		var secret *api.Secret
+		err := runWithContext(ctx, func() error {
+			var renewErr error
+			secret, renewErr = p.client.Auth().Token().RenewSelf(0)
+			return renewErr
+		})
+		if err == nil && secret != nil && secret.Auth != nil {
+			p.setLease(secret.Auth)
+			return p.client, nil
+		}
+		// the token reached its maximum TTL or was revoked, log in again
+	}
+
+	client, auth, err := p.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.client = client
+	p.setLease(auth)
+	return client, nil
+}
+
+// logout drops the token of client, so the next call logs in again. Called when a request
+// fails, as the token may have been revoked before its lease ended.
+func (p *VaultProvider) logout(client *api.Client) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.client == client {
+		p.client = nil
+	}
+}
+
+// setLease records when the token described by auth is to be renewed. Tokens without a
+// lease, such as a configured static token, are kept for good.
+func (p *VaultProvider) setLease(auth *api.SecretAuth) {
+	p.renewAt = time.Time{}
+	p.renewable = false
+	if auth != nil && auth.LeaseDuration > 0 {
+		p.renewAt = time.Now().Add(time.Duration(auth.LeaseDuration) * time.Second * 2 / 3)
+		p.renewable = auth.Renewable
+	}
+}
+
+// authenticate creates a Vault client and logs in with the configured auth method
+func (p *VaultProvider) authenticate(ctx context.Context) (*api.Client, *api.SecretAuth, error) {
+	conf := api.DefaultConfig()
+	if len(p.config.Address) > 0 {
+		conf.Address = p.config.Address
+	}
+	if err := conf.ConfigureTLS(&api.TLSConfig{CACert: p.config.CAPath, Insecure: p.config.InsecureSkipVerify}); err != nil {
+		return nil, nil, NewCertError("could not configure Vault TLS: " + err.Error())
+	}
+
+	client, err := api.NewClient(conf)
+	if err != nil {
+		return nil, nil, NewCertError("could not connect to Vault: " + err.Error())
+	}
+
+	auth := p.config.Auth
+	var data map[string]interface{}
+	switch auth.Method {
+	case "token":
+		if len(auth.Token) > 0 {
+			client.SetToken(auth.Token)
+		}
+		return client, nil, nil
+	case "kubernetes":
+		jwt := []byte(auth.JWT)
+		if len(jwt) == 0 {
+			jwt, err = ioutil.ReadFile(auth.TokenPath)
+			if err != nil {
+				return nil, nil, NewCertError("could not read service account token: " + err.Error())
+			}
+		}
+		data = map[string]interface{}{
+			"role": auth.Role,
+			"jwt":  strings.TrimSpace(string(jwt)),
+		}
+	case "approle":
+		data = map[string]interface{}{
+			"role_id":   auth.RoleID,
+			"secret_id": auth.SecretID,
+		}
+	}
+
+	path := strings.Trim(auth.Path, "/")
+	if len(path) == 0 {
+		path = auth.Method
+	}
+	secret, err := write(ctx, client, "auth/"+path+"/login", data)
+	if err != nil {
+		return nil, nil, NewCertError("could not log in to Vault: " + err.Error())
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, nil, NewCertError("Vault login returned no token")
+	}
+	client.SetToken(secret.Auth.ClientToken)
+
+	return client, secret.Auth, nil
+}
+
+// write performs a Vault write that is abandoned when ctx is done
+func write(ctx context.Context, client *api.Client, path string, data map[string]interface{}) (*api.Secret, error) {
+	var secret *api.Secret
+	err := runWithContext(ctx, func() error {
+		var writeErr error
+		secret, writeErr = client.Logical().Write(path, data)
+		return writeErr
+	})
+	return secret, err
+}
+
+// vaultSerial formats a serial number the way Vault does, as colon separated hex bytes
+func vaultSerial(serial *big.Int) string {
+	var parts []string
+	for _, b := range serial.Bytes() {
+		parts = append(parts, fmt.Sprintf("%02x", b))
+	}
+	return strings.Join(parts, ":")
+}
+
+// vaultChain returns the issuing chain from a PKI response, preferring the full ca_chain
+func vaultChain(data map[string]interface{}) []byte {
+	var chain []byte
+	if certs, ok := data["ca_chain"].([]interface{}); ok {
+		for _, c := range certs {
+			if pemCert, ok := c.(string); ok {
+				chain = append(chain, []byte(strings.TrimSpace(pemCert)+"\n")...)
+			}
+		}
+	}
+	if len(chain) == 0 {
+		if issuingCA, ok := data["issuing_ca"].(string); ok {
+			chain = []byte(strings.TrimSpace(issuingCA) + "\n")
+		}
+	}
+	return chain
+}
@@ -0,0 +1,159 @@
+package certs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestVaultProvisionAppRole(t *testing.T) {
+	// setup
+	req := NewCertificateRequest("test.example.com", "10.0.0.1")
+	req.Duration = time.Hour
+	issued, err := new(SelfSignedProvider).Provision(context.TODO(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var request map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/approle/login":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"auth": map[string]interface{}{"client_token": "s.test"},
+			})
+		case "/v1/pki/issue/web":
+			if r.Header.Get("X-Vault-Token") != "s.test" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			json.NewDecoder(r.Body).Decode(&request)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{
+					"certificate": string(issued.Cert),
+					"private_key": string(issued.Key),
+					"issuing_ca":  string(issued.Cert),
+				},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	provider, err := NewVaultProvider(VaultConfig{
+		Address: server.URL,
+		Mount:   "pki",
+		Role:    "web",
+		Mode:    "issue",
+		Auth: VaultAuthConfig{
+			Method:   "approle",
+			RoleID:   "role",
+			SecretID: "secret",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	keyPair, err := provider.Provision(context.TODO(), req)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if request["common_name"] != "test.example.com" || request["ip_sans"] != "10.0.0.1" {
+		t.Fatalf("invalid issue request: %v", request)
+	}
+
+	if string(keyPair.Key) != string(issued.Key) {
+		t.Fatal("private key was not taken from the Vault response")
+	}
+
+	if len(keyPair.CA) == 0 {
+		t.Fatal("no issuing CA returned")
+	}
+
+	if !keyPair.Expiry.Equal(issued.Expiry.Truncate(time.Second)) {
+		t.Fatal("expiry was not read from the certificate")
+	}
+}
+
+func TestVaultLoginCached(t *testing.T) {
+	// setup
+	req := NewCertificateRequest("test.example.com")
+	req.Duration = time.Hour
+	issued, err := new(SelfSignedProvider).Provision(context.TODO(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logins int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/approle/login":
+			atomic.AddInt32(&logins, 1)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"auth": map[string]interface{}{"client_token": "s.test", "lease_duration": 3600, "renewable": true},
+			})
+		case "/v1/pki/issue/web":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{
+					"certificate": string(issued.Cert),
+					"private_key": string(issued.Key),
+				},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config := VaultConfig{
+		Address: server.URL,
+		Mount:   "pki",
+		Role:    "web",
+		Mode:    "issue",
+		Auth: VaultAuthConfig{
+			Method:   "approle",
+			RoleID:   "role",
+			SecretID: "secret",
+		},
+	}
+	verifying, err := NewVaultProvider(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.InsecureSkipVerify = true
+	provider, err := NewVaultProvider(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	_, verifyErr := verifying.Provision(context.TODO(), req)
+	_, err = provider.Provision(context.TODO(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = provider.Provision(context.TODO(), req)
+
+	// assert
+	if verifyErr == nil {
+		t.Fatal("certificate of an untrusted server was accepted")
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if atomic.LoadInt32(&logins) != 1 {
+		t.Fatalf("expected 1 login, got %d", logins)
+	}
+}
@@ -1,21 +1,76 @@
 package certs
 
 import (
-	"crypto/tls"
-	"crypto/x509/pkix"
-	"github.com/Venafi/vcert"
-	"github.com/Venafi/vcert/pkg/certificate"
-	t "log"
+	"context"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
 	"net/http"
-	"time"
-	"io/ioutil"
 	"os"
+	"strings"
+	"time"
+
+	"github.com/Venafi/vcert"
+	"github.com/Venafi/vcert/pkg/certificate"
 	"github.com/Venafi/vcert/pkg/endpoint"
-	"encoding/json"
-	"fmt"
 )
 
+// VenafiConfig selects the Venafi product to connect to. Connector is `tpp` for Trust
+// Protection Platform, authenticating with a user and password, or `cloud` for Venafi
+// as a Service, authenticating with an API key. The certificate of the server is verified
+// against CAPath, or the system roots when it is empty, unless InsecureSkipVerify is set.
+type VenafiConfig struct {
+	Connector          string `json:"connector"`
+	URL                string `json:"url"`
+	Zone               string `json:"zone"`
+	User               string `json:"user"`
+	Password           string `json:"password"`
+	APIKey             string `json:"api-key"`
+	CAPath             string `json:"ca-path"`
+	InsecureSkipVerify bool   `json:"insecure-skip-verify"`
+}
+
 type VenafiProvider struct {
+	config VenafiConfig
+	// httpClient talks to TPP or Venafi Cloud
+	httpClient *http.Client
+}
+
+// WithEnvDefaults fills empty values from the VENAFI_* environment variables
+func (c VenafiConfig) WithEnvDefaults() VenafiConfig {
+	c.URL = valueOrEnv(c.URL, "VENAFI_API_URL")
+	c.Zone = valueOrEnv(c.Zone, "VENAFI_CERT_ZONE")
+	c.User = valueOrEnv(c.User, "VENAFI_USER_NAME")
+	c.Password = valueOrEnv(c.Password, "VENAFI_PASSWORD")
+	c.APIKey = valueOrEnv(c.APIKey, "VENAFI_API_KEY")
+	c.CAPath = valueOrEnv(c.CAPath, "VENAFI_CA_PATH")
+	return c
+}
+
+func NewVenafiProvider(config VenafiConfig) (*VenafiProvider, error) {
+	switch config.Connector {
+	case "tpp":
+		if len(config.URL) == 0 {
+			return nil, NewCertError("Venafi TPP requires an API URL")
+		}
+	case "cloud":
+		if len(config.APIKey) == 0 {
+			return nil, NewCertError("Venafi Cloud requires an API key")
+		}
+	default:
+		return nil, NewCertError("Unrecognized Venafi connector: " + config.Connector)
+	}
+	if len(config.Zone) == 0 {
+		return nil, NewCertError("Venafi requires a zone")
+	}
+
+	httpClient, err := newHTTPClient(config.CAPath, config.InsecureSkipVerify)
+	if err != nil {
+		return nil, err
+	}
+	return &VenafiProvider{config: config, httpClient: httpClient}, nil
 }
 
 /*
@@ -23,121 +78,247 @@ type VenafiProvider struct {
  https://github.com/Venafi/vcert/blob/master/example/main.go
 */
 
-func (p *VenafiProvider) Provision(host string, validFrom string, validFor time.Duration, isCA bool, rsaBits int, ecdsaCurve string, ssl string) (keypair KeyPair, certError error) {
+func (p *VenafiProvider) Provision(ctx context.Context, req CertificateRequest) (keypair KeyPair, certError error) {
 
-	if len(host) == 0 {
-		return KeyPair{}, NewErrBadHost("host cannot be empty")
+	pending, err := p.Submit(ctx, req)
+	if err != nil {
+		return KeyPair{}, err
 	}
 
-	var notBefore time.Time
-	var err error
-	if len(validFrom) == 0 {
-		notBefore = time.Now()
-	} else {
-		notBefore, err = time.Parse("Jan 2 15:04:05 2006", validFrom)
-		if err != nil {
-			return KeyPair{}, NewCertError("Failed to parse creation date: " + err.Error())
-		}
+	// wait for the certificate for as long as the call's deadline allows
+	var keyPair KeyPair
+	err = runWithContext(ctx, func() error {
+		var retrieveErr error
+		keyPair, retrieveErr = p.retrieve(pending, remaining(ctx, 180*time.Second))
+		return retrieveErr
+	})
+	return keyPair, err
+}
+
+// Submit sends the request to Venafi without waiting for it to be approved
+func (p *VenafiProvider) Submit(ctx context.Context, req CertificateRequest) (PendingRequest, error) {
+
+	if err := req.validate(); err != nil {
+		return PendingRequest{}, err
 	}
 
-	notAfter := notBefore.Add(validFor)
+	if len(req.URIs) > 0 {
+		return PendingRequest{}, NewCertError("Venafi does not support URI SANs")
+	}
 
-	var tppConfig = &vcert.Config{}
-	if ssl == "true" {
-		http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{}
+	enrollReq, err := p.enrollRequest(req)
+	if err != nil {
+		return PendingRequest{}, err
+	}
 
-		trustBundle, err := ioutil.ReadFile(os.Getenv("VENAFI_CA_PATH"))
-		if err != nil {
-			NewCertError("trust was not found in path")
-		}
-		trustBundlePEM := string(trustBundle)
-
-		tppConfig = &vcert.Config{
-				ConnectorType: endpoint.ConnectorTypeTPP,
-				BaseUrl:       os.Getenv("VENAFI_API_URL"),
-				ConnectionTrust: trustBundlePEM,
-				Credentials: &endpoint.Authentication{
-					User:     os.Getenv("VENAFI_USER_NAME"),
-					Password: os.Getenv("VENAFI_PASSWORD")},
-				Zone: os.Getenv("VENAFI_CERT_ZONE"),
-		}
+	// vcert does not take a context, so the exchange is abandoned when ctx is done
+	var pending PendingRequest
+	err = runWithContext(ctx, func() error {
+		var submitErr error
+		pending, submitErr = p.submit(enrollReq)
+		return submitErr
+	})
+	return pending, err
+}
 
-	} else {
-		http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+// Retrieve picks up a submitted certificate if Venafi has issued it
+func (p *VenafiProvider) Retrieve(ctx context.Context, pending PendingRequest) (KeyPair, error) {
+	var keyPair KeyPair
+	err := runWithContext(ctx, func() error {
+		var retrieveErr error
+		keyPair, retrieveErr = p.retrieve(pending, 0)
+		return retrieveErr
+	})
+	return keyPair, err
+}
 
-		tppConfig = &vcert.Config{
-				ConnectorType: endpoint.ConnectorTypeTPP,
-				BaseUrl:       os.Getenv("VENAFI_API_URL"),
-				Credentials: &endpoint.Authentication{
-					User:     os.Getenv("VENAFI_USER_NAME"),
-					Password: os.Getenv("VENAFI_PASSWORD")},
-				Zone: os.Getenv("VENAFI_CERT_ZONE"),
-		}
+func (p *VenafiProvider) submit(enrollReq *certificate.Request) (PendingRequest, error) {
+	c, err := p.client()
+	if err != nil {
+		return PendingRequest{}, err
 	}
 
-	c, err := vcert.NewClient(tppConfig)
+	err = c.GenerateRequest(nil, enrollReq)
 	if err != nil {
-		return KeyPair{}, NewCertError("could not connect to endpoint: " + err.Error())
+		return PendingRequest{}, NewCertError("could not generate certificate request: " + err.Error())
 	}
 
-	enrollReq := &certificate.Request{
-		Subject: pkix.Name{
-			CommonName:         host,
-			Organization:       []string{os.Getenv("VENAFI_ORGANIZATION")},
-			OrganizationalUnit: []string{os.Getenv("VENAFI_ORGANIZATION_UNIT")},
-			Locality:           []string{os.Getenv("VENAFI_LOCALITY")},
-			Province:           []string{os.Getenv("VENAFI_PROVINCE")},
-			Country:            []string{os.Getenv("VENAFI_COUNTRY")},
-		},
-		DNSNames:       []string{host},
-		CsrOrigin:      certificate.LocalGeneratedCSR,
-		KeyType:        certificate.KeyTypeRSA,
-		KeyLength:      2048,
-		ChainOption:    certificate.ChainOptionRootLast,
+	requestID, err := c.RequestCertificate(enrollReq)
+	if err != nil {
+		return PendingRequest{}, NewCertError("could not submit certificate request: " + err.Error())
 	}
+	log.Info("Submitted certificate request to Venafi", "RequestID", requestID)
 
-	err = c.GenerateRequest(nil, enrollReq)
+	pemBlock, err := pemBlockForKey(enrollReq.PrivateKey)
 	if err != nil {
-		return KeyPair{}, NewCertError("could not generate certificate request: " + err.Error())
+		return PendingRequest{}, err
 	}
 
-	requestID, err := c.RequestCertificate(enrollReq)
+	return PendingRequest{
+		ID:  requestID,
+		Key: pem.EncodeToMemory(pemBlock),
+	}, nil
+}
+
+// retrieve picks up the certificate, waiting up to timeout for it to be issued
+func (p *VenafiProvider) retrieve(pending PendingRequest, timeout time.Duration) (KeyPair, error) {
+	c, err := p.client()
 	if err != nil {
-		return KeyPair{}, NewCertError("could not submit certificate request: " + err.Error())
+		return KeyPair{}, err
 	}
-	t.Printf("Successfully submitted certificate request. Will pickup certificate by ID %s", requestID)
 
 	pickupReq := &certificate.Request{
-		PickupID: requestID,
-		Timeout:  180 * time.Second,
+		PickupID: pending.ID,
+		Timeout:  timeout,
 	}
 	pcc, err := c.RetrieveCertificate(pickupReq)
-	if err != nil {
+	switch err.(type) {
+	case nil:
+	case endpoint.ErrCertificatePending, endpoint.ErrRetrieveCertificateTimeout:
+		return KeyPair{}, NewErrCertificatePending("certificate " + pending.ID + " has not been issued yet")
+	default:
 		return KeyPair{}, NewCertError("could not retrieve certificate using requestId " + err.Error())
 	}
 
-	pcc.AddPrivateKey(enrollReq.PrivateKey, []byte(enrollReq.KeyPassword))
+	log.Info("Picked up certificate from Venafi", "RequestID", pending.ID)
 
-	t.Printf("Successfully picked up certificate for %s", host)
-	pp(pcc)
+	block, _ := pem.Decode([]byte(pcc.Certificate))
+	if block == nil {
+		return KeyPair{}, NewCertError("Venafi returned an invalid certificate")
+	}
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return KeyPair{}, NewCertError("could not parse issued certificate: " + err.Error())
+	}
 
-	var cert = []byte(pcc.Certificate)
-    var privateKey = []byte(pcc.PrivateKey)
+	var chain []byte
+	for _, ca := range pcc.Chain {
+		chain = append(chain, []byte(strings.TrimSpace(ca)+"\n")...)
+	}
 
 	return KeyPair{
-		cert,
-		privateKey,
-		notAfter}, nil
+		Cert:   []byte(pcc.Certificate),
+		Key:    pending.Key,
+		CA:     chain,
+		Expiry: leaf.NotAfter}, nil
+}
+
+// enrollRequest translates a CertificateRequest into a vcert request. Subject fields left
+// empty fall back to the VENAFI_* environment variables.
+func (p *VenafiProvider) enrollRequest(req CertificateRequest) (*certificate.Request, error) {
+	subject := req.Subject
+	subject.CommonName = req.CommonName()
+	if len(subject.Organization) == 0 {
+		subject.Organization = []string{os.Getenv("VENAFI_ORGANIZATION")}
+	}
+	if len(subject.OrganizationalUnit) == 0 {
+		subject.OrganizationalUnit = []string{os.Getenv("VENAFI_ORGANIZATION_UNIT")}
+	}
+	if len(subject.Locality) == 0 {
+		subject.Locality = []string{os.Getenv("VENAFI_LOCALITY")}
+	}
+	if len(subject.Province) == 0 {
+		subject.Province = []string{os.Getenv("VENAFI_PROVINCE")}
+	}
+	if len(subject.Country) == 0 {
+		subject.Country = []string{os.Getenv("VENAFI_COUNTRY")}
+	}
+
+	enrollReq := &certificate.Request{
+		Subject:        subject,
+		DNSNames:       req.DNSNames,
+		IPAddresses:    req.IPAddresses,
+		EmailAddresses: req.EmailAddresses,
+		CsrOrigin:      certificate.LocalGeneratedCSR,
+		ChainOption:    certificate.ChainOptionRootLast,
+	}
+
+	switch req.KeyAlgorithm {
+	case RSAKey, "":
+		enrollReq.KeyType = certificate.KeyTypeRSA
+		enrollReq.KeyLength = req.KeySize
+		if enrollReq.KeyLength == 0 {
+			enrollReq.KeyLength = 2048
+		}
+	case ECDSAKey:
+		enrollReq.KeyType = certificate.KeyTypeECDSA
+		switch req.KeySize {
+		case 256, 0:
+			enrollReq.KeyCurve = certificate.EllipticCurveP256
+		case 384:
+			enrollReq.KeyCurve = certificate.EllipticCurveP384
+		case 521:
+			enrollReq.KeyCurve = certificate.EllipticCurveP521
+		default:
+			return nil, NewCertError(fmt.Sprintf("Unsupported elliptic curve size for Venafi: %d", req.KeySize))
+		}
+	default:
+		return nil, NewCertError("Unrecognized key algorithm:" + string(req.KeyAlgorithm))
+	}
+
+	return enrollReq, nil
 }
 
-func (p *VenafiProvider) Deprovision(host string) error {
-	return nil
+// Deprovision revokes the certificate by its thumbprint
+func (p *VenafiProvider) Deprovision(ctx context.Context, cert []byte) error {
+	leaf, err := ParseCertificate(cert)
+	if err != nil {
+		return err
+	}
+	thumbprint := sha1.Sum(leaf.Raw)
+
+	return runWithContext(ctx, func() error {
+		c, err := p.client()
+		if err != nil {
+			return err
+		}
+
+		err = c.RevokeCertificate(&certificate.RevocationRequest{
+			Thumbprint: strings.ToUpper(hex.EncodeToString(thumbprint[:])),
+			Reason:     "cessation-of-operation",
+		})
+		if err != nil {
+			return NewCertError("could not revoke certificate: " + err.Error())
+		}
+		log.Info("Revoked certificate in Venafi", "SerialNumber", leaf.SerialNumber.Text(16))
+		return nil
+	})
 }
 
-var pp = func(a interface{}) {
-	b, err := json.MarshalIndent(a, "", "    ")
+func (p *VenafiProvider) client() (endpoint.Connector, error) {
+	c, err := vcert.NewClient(p.vcertConfig())
 	if err != nil {
-		fmt.Println("error:", err)
+		return nil, NewCertError("could not connect to endpoint: " + err.Error())
+	}
+	return c, nil
+}
+
+// vcertConfig builds the connector configuration for TPP or Venafi Cloud
+func (p *VenafiProvider) vcertConfig() *vcert.Config {
+	vcertConfig := &vcert.Config{
+		BaseUrl: p.config.URL,
+		Zone:    p.config.Zone,
+		Client:  p.httpClient,
+	}
+
+	switch p.config.Connector {
+	case "cloud":
+		vcertConfig.ConnectorType = endpoint.ConnectorTypeCloud
+		vcertConfig.Credentials = &endpoint.Authentication{
+			APIKey: p.config.APIKey}
+	default:
+		vcertConfig.ConnectorType = endpoint.ConnectorTypeTPP
+		vcertConfig.Credentials = &endpoint.Authentication{
+			User:     p.config.User,
+			Password: p.config.Password}
 	}
-	t.Println(string(b))
-} 
+
+	return vcertConfig
+}
+
+func valueOrEnv(value string, key string) string {
+	if len(value) > 0 {
+		return value
+	}
+	return os.Getenv(key)
+}
@@ -0,0 +1,110 @@
+package certs
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// newVenafiServer returns a TLS server that answers every request with 401 and records the
+// paths and API keys it was sent, and the path of a PEM file holding its certificate
+func newVenafiServer(t *testing.T) (*httptest.Server, *[]string, string) {
+	var requests []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+r.Header.Get("tppl-api-key"))
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+
+	caFile, err := ioutil.TempFile("", "venafi-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer caFile.Close()
+	if err := pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}); err != nil {
+		t.Fatal(err)
+	}
+	return server, &requests, caFile.Name()
+}
+
+func TestVenafiConnectorSelection(t *testing.T) {
+	server, requests, caPath := newVenafiServer(t)
+	defer server.Close()
+	defer os.Remove(caPath)
+
+	tests := []struct {
+		name     string
+		config   VenafiConfig
+		expected string
+	}{
+		{
+			name:     "tpp",
+			config:   VenafiConfig{Connector: "tpp", URL: server.URL + "/vedsdk", Zone: "certs", User: "admin", Password: "secret", CAPath: caPath},
+			expected: "POST /vedsdk/authorize/ ",
+		},
+		{
+			name:     "cloud",
+			config:   VenafiConfig{Connector: "cloud", URL: server.URL + "/v1", Zone: "certs", APIKey: "api-key", CAPath: caPath},
+			expected: "GET /v1/useraccounts api-key",
+		},
+	}
+	for _, test := range tests {
+		// setup
+		*requests = nil
+		provider, err := NewVenafiProvider(test.config)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		// act
+		_, err = provider.client()
+
+		// assert
+		if err == nil {
+			t.Fatalf("%s: expected the login to be refused", test.name)
+		}
+		if len(*requests) != 1 || !strings.HasPrefix((*requests)[0], test.expected) {
+			t.Fatalf("%s: expected %q, got %v", test.name, test.expected, *requests)
+		}
+	}
+}
+
+func TestVenafiVerifiesServer(t *testing.T) {
+	// setup
+	server, requests, caPath := newVenafiServer(t)
+	defer server.Close()
+	defer os.Remove(caPath)
+	provider, err := NewVenafiProvider(VenafiConfig{Connector: "cloud", URL: server.URL + "/v1", Zone: "certs", APIKey: "api-key"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	_, err = provider.client()
+
+	// assert
+	if err == nil || len(*requests) > 0 {
+		t.Fatal("API key was sent to a server whose certificate is not trusted")
+	}
+	if config := http.DefaultTransport.(*http.Transport).TLSClientConfig; config != nil && (config.InsecureSkipVerify || config.RootCAs != nil) {
+		t.Fatal("TLS settings of the default transport were changed")
+	}
+}
+
+func TestVenafiConfigErrors(t *testing.T) {
+	tests := map[string]VenafiConfig{
+		"Venafi TPP requires an API URL":   {Connector: "tpp", Zone: "certs"},
+		"Venafi Cloud requires an API key": {Connector: "cloud", Zone: "certs"},
+		"Venafi requires a zone":           {Connector: "cloud", APIKey: "api-key"},
+		"Unrecognized Venafi connector: x": {Connector: "x"},
+		"could not read CA bundle":         {Connector: "cloud", APIKey: "api-key", Zone: "certs", CAPath: "/nonexistent"},
+	}
+	for expected, config := range tests {
+		if _, err := NewVenafiProvider(config); err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("expected %q, got %v", expected, err)
+		}
+	}
+}
@@ -5,6 +5,21 @@ notifiers:
 - name: myslacknotifier
   kind: slack
   integration_url: https://hooks.slack.com/services/service_id/auth-token
+- name: mywebhook
+  kind: webhook
+  integration_url: https://events.example.com/certificates
+  headers:
+    Authorization: Bearer my-token
+- name: mymail
+  kind: smtp
+  events: [failed, expiring]
+  smtp:
+    host: smtp.example.com
+    port: 587
+    username: cert-operator
+    password: my-password
+    from: cert-operator@example.com
+    to: [ops@example.com]
 */
 
 package config
@@ -12,6 +27,7 @@ package config
 import (
 	"encoding/json"
 	"os"
+	"time"
 
 	config "github.com/micro/go-config"
 	"github.com/micro/go-config/source/env"
@@ -19,27 +35,63 @@ import (
 	"github.com/micro/go-config/source/flag"
 	"github.com/micro/go-config/source/memory"
 	"github.com/redhat-cop/cert-operator/pkg/certs"
+	"github.com/redhat-cop/cert-operator/pkg/notifier"
 	"github.com/sirupsen/logrus"
 )
 
 type Config struct {
-	//	Notifiers []notifier.Notifier  `json:"notifiers"`
-	Provider certs.ProviderConfig `json:"provider"`
-	General  GeneralConfig        `json:"general"`
+	Notifiers []notifier.Config    `json:"notifiers"`
+	Provider  certs.ProviderConfig `json:"provider"`
+	General   GeneralConfig        `json:"general"`
 }
 
 type GeneralConfig struct {
-	Annotations AnnotationConfig `json:"annotations"`
+	Annotations   AnnotationConfig `json:"annotations"`
+	PollInterval  string           `json:"poll-interval"`
+	Duration      string           `json:"duration"`
+	Renewal       RenewalConfig    `json:"renewal"`
+	ExpiryWarning string           `json:"expiry-warning"`
+	ClusterDomain string           `json:"cluster-domain"`
+	// ServiceSANs lists the optional names and addresses of a Service its certificate
+	// covers: short-names, cluster-ip and load-balancer. The service-sans annotation of a
+	// Service takes its place.
+	ServiceSANs []string `json:"service-sans"`
+}
+
+// Optional names and addresses of a Service its certificate can cover
+const (
+	ServiceSANShortNames   = "short-names"
+	ServiceSANClusterIP    = "cluster-ip"
+	ServiceSANLoadBalancer = "load-balancer"
+)
+
+// RenewalConfig sets when certificates are renewed. Before is a fixed window ahead of
+// expiry, e.g. `720h`; when it is empty a certificate is renewed once Ratio of its
+// lifetime has passed.
+type RenewalConfig struct {
+	Before string  `json:"before"`
+	Ratio  float64 `json:"ratio"`
 }
 
 type AnnotationConfig struct {
-	Status        string `json:"status"`
-	StatusReason  string `json:"status-reason"`
-	Expiry        string `json:"expiry"`
-	Format        string `json:"format"`
-	NeedCertValue string `json:"need-cert-value"`
-	PemFormat     string `json:"pem-format-value"`
-	Pkcs12Format  string `json:"pkcs12-format-value"`
+	Status            string `json:"status"`
+	StatusReason      string `json:"status-reason"`
+	Expiry            string `json:"expiry"`
+	Format            string `json:"format"`
+	NeedCertValue     string `json:"need-cert-value"`
+	PemFormat         string `json:"pem-format-value"`
+	Pkcs12Format      string `json:"pkcs12-format-value"`
+	RequestID         string `json:"request-id"`
+	Issued            string `json:"issued"`
+	Issuer            string `json:"issuer"`
+	ClusterIssuer     string `json:"cluster-issuer"`
+	Hosts             string `json:"hosts"`
+	SourceSecret      string `json:"source-secret"`
+	PassthroughSecret string `json:"passthrough-secret"`
+	SharedCertificate string `json:"shared-certificate"`
+	Wildcard          string `json:"wildcard"`
+	ExtraSANs         string `json:"extra-sans"`
+	ServiceSANs       string `json:"service-sans"`
 }
 
 const (
@@ -54,16 +106,68 @@ const (
         "format": "openshift.io/cert-ctl-format",
         "need-cert-value": "new",
         "pem-format-value": "PEM",
-        "pkcs12-format-value": "PKCS12"
-      }
+        "pkcs12-format-value": "PKCS12",
+        "request-id": "openshift.io/cert-ctl-request-id",
+        "issued": "openshift.io/cert-ctl-issued",
+        "issuer": "openshift.io/cert-ctl-issuer",
+        "cluster-issuer": "openshift.io/cert-ctl-cluster-issuer",
+        "hosts": "openshift.io/cert-ctl-hosts",
+        "source-secret": "openshift.io/cert-ctl-source-secret",
+        "passthrough-secret": "openshift.io/cert-ctl-passthrough-secret",
+        "shared-certificate": "openshift.io/cert-ctl-shared-certificate",
+        "wildcard": "openshift.io/cert-ctl-wildcard",
+        "extra-sans": "openshift.io/cert-ctl-extra-sans",
+        "service-sans": "openshift.io/cert-ctl-service-sans"
+      },
+      "poll-interval": "30s",
+      "duration": "8760h",
+      "renewal": {
+        "ratio": 0.67
+      },
+      "expiry-warning": "168h",
+      "cluster-domain": "cluster.local"
     },
     "provider": {
       "kind": "self-signed",
-      "ssl": "false"
+      "ca": {
+        "secret-name": "cert-operator-ca",
+        "common-name": "cert-operator CA",
+        "organization": "cert-operator",
+        "validity": "87600h"
+      },
+      "acme": {
+        "directory-url": "https://acme-staging-v02.api.letsencrypt.org/directory",
+        "account-secret": "cert-operator-acme-account",
+        "solver-image": "registry.access.redhat.com/ubi8/httpd-24",
+        "solver-port": 8080,
+        "solver-docroot": "/var/www/html"
+      },
+      "vault": {
+        "address": "https://vault.vault.svc:8200",
+        "mount": "pki",
+        "mode": "issue",
+        "auth": {
+          "method": "kubernetes",
+          "token-path": "/var/run/secrets/kubernetes.io/serviceaccount/token"
+        }
+      },
+      "venafi": {
+        "connector": "tpp"
+      }
     }
   }`
 )
 
+// DefaultConfig returns the configuration the operator runs with when neither a config
+// file nor the environment override it
+func DefaultConfig() Config {
+	var conf Config
+	if err := json.Unmarshal([]byte(defaultConfig), &conf); err != nil {
+		panic(err)
+	}
+	return conf
+}
+
 func NewConfig() Config {
 
 	tmpConfig := config.NewConfig()
@@ -98,6 +202,76 @@ func getConfigFile() (configFile string) {
 	return defaultConfigFile
 }
 
+// PollDuration returns how long to wait before checking on a pending certificate request
+func (c GeneralConfig) PollDuration() time.Duration {
+	interval, err := time.ParseDuration(c.PollInterval)
+	if err != nil || interval <= 0 {
+		return 30 * time.Second
+	}
+	return interval
+}
+
+// CertDuration returns the validity requested for new certificates
+func (c GeneralConfig) CertDuration() time.Duration {
+	duration, err := time.ParseDuration(c.Duration)
+	if err != nil || duration <= 0 {
+		return 8760 * time.Hour
+	}
+	return duration
+}
+
+// ExpiryWarningDuration returns how close to its expiry a certificate that fails to renew
+// is reported as expiring
+func (c GeneralConfig) ExpiryWarningDuration() time.Duration {
+	warning, err := time.ParseDuration(c.ExpiryWarning)
+	if err != nil || warning <= 0 {
+		return 168 * time.Hour
+	}
+	return warning
+}
+
+// MinRenewalDelay is the least time a certificate is kept before it is renewed, however short
+// its lifetime
+const MinRenewalDelay = 5 * time.Minute
+
+// RenewAt returns when a certificate valid from issued until expiry is due for renewal, but
+// no earlier than MinRenewalDelay after it was issued. A Before window that does not fit in
+// the lifetime of the certificate is ignored in favour of Ratio.
+func (c RenewalConfig) RenewAt(issued time.Time, expiry time.Time) time.Time {
+	lifetime := expiry.Sub(issued)
+
+	var renewAt time.Time
+	if before, err := time.ParseDuration(c.Before); err == nil && before > 0 && before < lifetime {
+		renewAt = expiry.Add(-before)
+	} else {
+		ratio := c.Ratio
+		if ratio <= 0 || ratio >= 1 {
+			ratio = 0.67
+		}
+		renewAt = issued.Add(time.Duration(float64(lifetime) * ratio))
+	}
+
+	if earliest := issued.Add(MinRenewalDelay); renewAt.Before(earliest) {
+		return earliest
+	}
+	return renewAt
+}
+
+// Validate returns an error for general settings the operator cannot work with
+func (c GeneralConfig) Validate() error {
+	if len(c.Renewal.Before) == 0 {
+		return nil
+	}
+	before, err := time.ParseDuration(c.Renewal.Before)
+	if err != nil {
+		return certs.NewCertError("invalid renewal before: " + err.Error())
+	}
+	if before >= c.CertDuration() {
+		return certs.NewCertError("renewal before " + c.Renewal.Before + " must be shorter than the certificate duration " + c.CertDuration().String())
+	}
+	return nil
+}
+
 func (c *Config) String() string {
 	out, err := json.Marshal(c)
 	if err != nil {
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenewAt(t *testing.T) {
+	issued := time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)
+	year := issued.Add(365 * 24 * time.Hour)
+
+	tests := []struct {
+		name    string
+		renewal RenewalConfig
+		expiry  time.Time
+		want    time.Time
+	}{
+		{"default ratio", RenewalConfig{}, issued.Add(100 * time.Hour), issued.Add(67 * time.Hour)},
+		{"ratio", RenewalConfig{Ratio: 0.5}, issued.Add(100 * time.Hour), issued.Add(50 * time.Hour)},
+		{"invalid ratio", RenewalConfig{Ratio: 1.5}, issued.Add(100 * time.Hour), issued.Add(67 * time.Hour)},
+		{"before", RenewalConfig{Before: "720h"}, year, year.Add(-720 * time.Hour)},
+		{"before longer than lifetime", RenewalConfig{Before: "720h", Ratio: 0.5}, issued.Add(100 * time.Hour), issued.Add(50 * time.Hour)},
+		{"before equal to lifetime", RenewalConfig{Before: "100h", Ratio: 0.5}, issued.Add(100 * time.Hour), issued.Add(50 * time.Hour)},
+		{"invalid before", RenewalConfig{Before: "soon", Ratio: 0.5}, issued.Add(100 * time.Hour), issued.Add(50 * time.Hour)},
+		{"expired on issue", RenewalConfig{}, issued, issued.Add(MinRenewalDelay)},
+		{"expiry before issue", RenewalConfig{Before: "720h"}, issued.Add(-time.Hour), issued.Add(MinRenewalDelay)},
+		{"short lifetime", RenewalConfig{}, issued.Add(time.Minute), issued.Add(MinRenewalDelay)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// act
+			got := test.renewal.RenewAt(issued, test.expiry)
+
+			// assert
+			if !got.Equal(test.want) {
+				t.Fatalf("expected %s, got %s", test.want, got)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		general GeneralConfig
+		valid   bool
+	}{
+		{"ratio", GeneralConfig{Duration: "8760h", Renewal: RenewalConfig{Ratio: 0.67}}, true},
+		{"before", GeneralConfig{Duration: "8760h", Renewal: RenewalConfig{Before: "720h"}}, true},
+		{"before default duration", GeneralConfig{Renewal: RenewalConfig{Before: "720h"}}, true},
+		{"before equal to duration", GeneralConfig{Duration: "720h", Renewal: RenewalConfig{Before: "720h"}}, false},
+		{"before longer than duration", GeneralConfig{Duration: "24h", Renewal: RenewalConfig{Before: "720h"}}, false},
+		{"invalid before", GeneralConfig{Renewal: RenewalConfig{Before: "soon"}}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// act
+			err := test.general.Validate()
+
+			// assert
+			if test.valid && err != nil {
+				t.Fatal(err)
+			}
+			if !test.valid && err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
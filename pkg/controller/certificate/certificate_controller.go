@@ -0,0 +1,427 @@
+package certificate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	certsv1alpha1 "github.com/redhat-cop/cert-operator/pkg/apis/certs/v1alpha1"
+	"github.com/redhat-cop/cert-operator/pkg/certs"
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	"github.com/redhat-cop/cert-operator/pkg/helpers"
+	"github.com/redhat-cop/cert-operator/pkg/issuer"
+	"github.com/redhat-cop/cert-operator/pkg/metrics"
+	"github.com/redhat-cop/cert-operator/pkg/notifier"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var log = logf.Log.WithName("controller_certificate")
+
+// Add creates a new Certificate Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager, config certconf.Config, issuers *issuer.Resolver, notifications *notifier.Dispatcher) error {
+	return add(mgr, newReconciler(mgr, config, issuers, notifications))
+}
+
+// newReconciler returns a new reconcile.Reconciler
+func newReconciler(mgr manager.Manager, config certconf.Config, issuers *issuer.Resolver,
+	notifications *notifier.Dispatcher) reconcile.Reconciler {
+	ctx, err := helpers.ManagerContext(mgr)
+	if err != nil {
+		panic("There was a problem registering the shutdown handler. \n" +
+			"\t" + err.Error())
+	}
+
+	return &ReconcileCertificate{client: mgr.GetClient(), scheme: mgr.GetScheme(), config: config, issuers: issuers, ctx: ctx,
+		recorder: mgr.GetRecorder("certificate-controller"), notifications: notifications}
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, r reconcile.Reconciler) error {
+	// Create a new controller
+	c, err := controller.New("certificate-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to the spec of primary resource Certificate and its deletion, status updates are our own
+	err = c.Watch(&source.Kind{Type: &certsv1alpha1.Certificate{}}, &handler.EnqueueRequestForObject{}, predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return e.MetaOld.GetGeneration() != e.MetaNew.GetGeneration() || e.MetaNew.GetDeletionTimestamp() != nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to secondary resource Secrets and requeue the owner Certificate
+	err = c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &certsv1alpha1.Certificate{},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var _ reconcile.Reconciler = &ReconcileCertificate{}
+
+// ReconcileCertificate reconciles a Certificate object
+type ReconcileCertificate struct {
+	// This client, initialized using mgr.Client() above, is a split client
+	// that reads objects from the cache and writes to the apiserver
+	client  client.Client
+	scheme  *runtime.Scheme
+	config  certconf.Config
+	issuers *issuer.Resolver
+	// ctx is cancelled when the manager stops, abandoning in-flight provider calls
+	ctx           context.Context
+	recorder      record.EventRecorder
+	notifications *notifier.Dispatcher
+}
+
+// Reconcile issues the certificate described by a Certificate into its Secret and renews it ahead
+// of expiry. The certificate is issued again whenever the spec changes or the Secret goes missing.
+// Note:
+// The Controller will requeue the Request to be processed again if the returned error is non-nil or
+// Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
+func (r *ReconcileCertificate) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
+
+	// Fetch the Certificate instance
+	instance := &certsv1alpha1.Certificate{}
+	err := r.client.Get(context.TODO(), request.NamespacedName, instance)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+			// Return and don't requeue
+			metrics.DeleteExpiry("Certificate", request.Namespace, request.Name)
+			return reconcile.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		return reconcile.Result{}, err
+	}
+
+	if instance.GetDeletionTimestamp() != nil {
+		return reconcile.Result{}, r.revoke(instance)
+	}
+
+	iss, err := r.issuers.Resolve(r.ctx, instance.Namespace, instance.Spec.IssuerRef)
+	if err != nil {
+		// the issuer may not have been created yet, retry with backoff
+		r.recorder.Event(instance, corev1.EventTypeWarning, helpers.EventProviderUnavailable, err.Error())
+		instance.Status.SetCondition(certsv1alpha1.CertificateCondition{
+			Type:    certsv1alpha1.CertificateReady,
+			Status:  corev1.ConditionFalse,
+			Reason:  "IssuerUnavailable",
+			Message: err.Error(),
+		})
+		if updateErr := r.client.Status().Update(context.TODO(), instance); updateErr != nil {
+			return reconcile.Result{}, updateErr
+		}
+		return reconcile.Result{}, err
+	}
+
+	if err := r.validate(instance, iss); err != nil {
+		// nothing to retry until the spec is fixed
+		instance.Status.SetCondition(certsv1alpha1.CertificateCondition{
+			Type:    certsv1alpha1.CertificateReady,
+			Status:  corev1.ConditionFalse,
+			Reason:  "InvalidSpec",
+			Message: err.Error(),
+		})
+		return reconcile.Result{}, r.client.Status().Update(context.TODO(), instance)
+	}
+
+	// revoke the certificate before the Certificate goes away
+	if !helpers.HasFinalizer(instance, helpers.Finalizer) {
+		helpers.AddFinalizer(instance, helpers.Finalizer)
+		if err := r.client.Update(context.TODO(), instance); err != nil {
+			return reconcile.Result{}, err
+		}
+	}
+
+	secret := &corev1.Secret{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Namespace: instance.Namespace, Name: instance.Spec.SecretName}, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		return reconcile.Result{}, err
+	}
+	secretFound := err == nil
+
+	// spec.secretName can name any Secret, only one the Certificate created is written to
+	if secretFound && !metav1.IsControlledBy(secret, instance) {
+		instance.Status.SetCondition(certsv1alpha1.CertificateCondition{
+			Type:    certsv1alpha1.CertificateReady,
+			Status:  corev1.ConditionFalse,
+			Reason:  "SecretConflict",
+			Message: "secret " + instance.Spec.SecretName + " exists and is not owned by the Certificate",
+		})
+		return reconcile.Result{}, r.client.Status().Update(context.TODO(), instance)
+	}
+
+	// a secret that was emptied or modified is overwritten with a new certificate
+	intact := false
+	if secretFound {
+		cert := helpers.SecretCert(secret, instance.Spec.Format == r.config.General.Annotations.Pkcs12Format)
+		intact = cert != nil && fmt.Sprintf("%x", cert.SerialNumber) == instance.Status.Serial
+	}
+
+	if intact && instance.Status.NotAfter != nil {
+		metrics.SetExpiry("Certificate", instance.Namespace, instance.Name, instance.Status.NotAfter.Time)
+	}
+
+	if intact && instance.Status.ObservedGeneration == instance.Generation && instance.Status.NotAfter != nil {
+		wait := time.Until(r.renewAt(instance))
+		if wait > 0 {
+			return reconcile.Result{RequeueAfter: wait}, nil
+		}
+		reqLogger.Info("Renewing certificate")
+	}
+
+	reqLogger.Info("Reconciling Certificate")
+
+	certReq := r.certificateRequest(instance, iss)
+	requestID := instance.Annotations[r.config.General.Annotations.RequestID]
+	keyPair, issued, err := helpers.ObtainCert(r.ctx, r.client, r.scheme, r.config, instance, certReq, iss.Provider, instance.Spec.SecretName+"-pending-key")
+	metrics.ObserveIssue(iss.Kind, instance.Namespace, issued, err)
+	if r.ctx.Err() != nil {
+		// shutting down, leave the status alone so the next leader picks it up
+		return reconcile.Result{}, r.ctx.Err()
+	}
+
+	// ObtainCert keeps track of pending requests in the annotations
+	if instance.Annotations[r.config.General.Annotations.RequestID] != requestID {
+		if updateErr := r.client.Update(context.TODO(), instance); updateErr != nil {
+			return reconcile.Result{}, updateErr
+		}
+	}
+
+	if err != nil {
+		var expiry time.Time
+		if secretFound && instance.Status.NotAfter != nil {
+			expiry = instance.Status.NotAfter.Time
+		}
+		r.recorder.Event(instance, corev1.EventTypeWarning, helpers.EventIssueFailed, err.Error())
+		helpers.NotifyFailed(r.ctx, r.notifications, r.config, "Certificate", instance, certReq.Hosts(), expiry, err)
+
+		now := metav1.Now()
+		instance.Status.LastFailureTime = &now
+		instance.Status.LastFailureMessage = err.Error()
+		instance.Status.SetCondition(certsv1alpha1.CertificateCondition{
+			Type:    certsv1alpha1.CertificateIssuing,
+			Status:  corev1.ConditionFalse,
+			Reason:  "IssueFailed",
+			Message: err.Error(),
+		})
+		if !secretFound {
+			instance.Status.SetCondition(certsv1alpha1.CertificateCondition{
+				Type:    certsv1alpha1.CertificateReady,
+				Status:  corev1.ConditionFalse,
+				Reason:  "IssueFailed",
+				Message: err.Error(),
+			})
+		}
+		if updateErr := r.client.Status().Update(context.TODO(), instance); updateErr != nil {
+			return reconcile.Result{}, updateErr
+		}
+		// retry with backoff
+		return reconcile.Result{}, err
+	}
+
+	if !issued {
+		// the CA has not issued the certificate yet, collect it on a later reconcile
+		instance.Status.SetCondition(certsv1alpha1.CertificateCondition{
+			Type:    certsv1alpha1.CertificateIssuing,
+			Status:  corev1.ConditionTrue,
+			Reason:  "Pending",
+			Message: "Waiting for the certificate to be issued",
+		})
+		if updateErr := r.client.Status().Update(context.TODO(), instance); updateErr != nil {
+			return reconcile.Result{}, updateErr
+		}
+		reqLogger.Info("Waiting for certificate to be issued")
+		return reconcile.Result{RequeueAfter: r.config.General.PollDuration()}, nil
+	}
+
+	leaf, err := keyPair.Leaf()
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	dm, secretType, err := helpers.SecretData(keyPair, instance.Spec.Format == r.config.General.Annotations.Pkcs12Format)
+	if err != nil {
+		reqLogger.Error(err, "Failed to convert to PKCS12")
+		return reconcile.Result{}, err
+	}
+
+	certSec := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      instance.Spec.SecretName,
+			Namespace: instance.Namespace,
+		},
+		Data: dm,
+		Type: secretType,
+	}
+	if err := controllerutil.SetControllerReference(instance, certSec, r.scheme); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	err = helpers.Apply(r.client, certSec)
+	if err != nil {
+		reqLogger.Error(err, "Failed to apply secret")
+		return reconcile.Result{}, err
+	}
+
+	renewing := instance.Status.NotAfter != nil
+	notBefore := metav1.NewTime(leaf.NotBefore)
+	notAfter := metav1.NewTime(leaf.NotAfter)
+	instance.Status.ObservedGeneration = instance.Generation
+	instance.Status.Serial = fmt.Sprintf("%x", leaf.SerialNumber)
+	instance.Status.NotBefore = &notBefore
+	instance.Status.NotAfter = &notAfter
+	instance.Status.SetCondition(certsv1alpha1.CertificateCondition{
+		Type:   certsv1alpha1.CertificateIssuing,
+		Status: corev1.ConditionFalse,
+		Reason: "Issued",
+	})
+	instance.Status.SetCondition(certsv1alpha1.CertificateCondition{
+		Type:    certsv1alpha1.CertificateReady,
+		Status:  corev1.ConditionTrue,
+		Reason:  "Issued",
+		Message: "Certificate is valid until " + leaf.NotAfter.Format(helpers.TimeFormat),
+	})
+	err = r.client.Status().Update(context.TODO(), instance)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	metrics.SetExpiry("Certificate", instance.Namespace, instance.Name, leaf.NotAfter)
+	r.recorder.Eventf(instance, corev1.EventTypeNormal, helpers.IssuedReason(renewing), "Certificate issued, valid until %s", leaf.NotAfter.UTC().Format(helpers.TimeFormat))
+	helpers.NotifyIssued(r.ctx, r.notifications, "Certificate", instance, certReq.Hosts(), renewing, leaf.NotAfter)
+	reqLogger.Info("Updated secret with new certificate")
+
+	return reconcile.Result{RequeueAfter: helpers.RequeueAfter(r.renewAt(instance))}, nil
+}
+
+// revoke revokes the certificate in the Secret of a deleted Certificate and releases it
+func (r *ReconcileCertificate) revoke(instance *certsv1alpha1.Certificate) error {
+	if !helpers.HasFinalizer(instance, helpers.Finalizer) {
+		return nil
+	}
+	reqLogger := log.WithValues("Request.Namespace", instance.Namespace, "Request.Name", instance.Name)
+
+	secret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: instance.Namespace, Name: instance.Spec.SecretName}, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	// a Secret the Certificate does not own holds a certificate the operator did not issue for it
+	if err == nil && metav1.IsControlledBy(secret, instance) && len(secret.Data["tls.crt"]) > 0 {
+		iss, err := r.issuers.Resolve(r.ctx, instance.Namespace, instance.Spec.IssuerRef)
+		if errors.IsNotFound(err) {
+			reqLogger.Info("Issuer is gone, not revoking certificate")
+		} else if err != nil {
+			return err
+		} else {
+			if err := iss.Provider.Deprovision(r.ctx, secret.Data["tls.crt"]); err != nil {
+				reqLogger.Error(err, "Failed to revoke certificate")
+				return err
+			}
+			reqLogger.Info("Revoked certificate of deleted Certificate")
+		}
+	}
+
+	helpers.RemoveFinalizer(instance, helpers.Finalizer)
+	return r.client.Update(context.TODO(), instance)
+}
+
+// validate rejects specs the operator cannot issue a certificate for
+func (r *ReconcileCertificate) validate(instance *certsv1alpha1.Certificate, iss issuer.Issuer) error {
+	spec := instance.Spec
+	if len(spec.Hosts) == 0 {
+		return certs.NewCertError("spec.hosts must name at least one host")
+	}
+	if len(spec.SecretName) == 0 {
+		return certs.NewCertError("spec.secretName is required")
+	}
+	switch spec.Format {
+	case "", r.config.General.Annotations.PemFormat, r.config.General.Annotations.Pkcs12Format:
+	default:
+		return certs.NewCertError("unsupported format " + spec.Format)
+	}
+	duration := r.duration(instance, iss)
+	if duration <= 0 {
+		return certs.NewCertError("spec.duration must be positive")
+	}
+	if spec.RenewBefore != nil && (spec.RenewBefore.Duration <= 0 || spec.RenewBefore.Duration >= duration) {
+		return certs.NewCertError("spec.renewBefore must be positive and shorter than the duration of " + duration.String())
+	}
+	if len(spec.Provider) > 0 && spec.Provider != iss.Kind {
+		return certs.NewCertError("provider " + spec.Provider + " does not match the issuer, which issues certificates with " + iss.Kind)
+	}
+	switch certs.KeyAlgorithm(spec.KeyAlgorithm) {
+	case "", certs.RSAKey, certs.ECDSAKey:
+	default:
+		return certs.NewCertError("unsupported key algorithm " + spec.KeyAlgorithm)
+	}
+	return nil
+}
+
+// certificateRequest translates the spec into a request for the provider
+func (r *ReconcileCertificate) certificateRequest(instance *certsv1alpha1.Certificate, iss issuer.Issuer) certs.CertificateRequest {
+	req := certs.NewCertificateRequest(instance.Spec.Hosts...)
+	req.Options[certs.OptionNamespace] = instance.Namespace
+
+	req.Duration = r.duration(instance, iss)
+
+	if len(instance.Spec.KeyAlgorithm) > 0 {
+		req.KeyAlgorithm = certs.KeyAlgorithm(instance.Spec.KeyAlgorithm)
+		req.KeySize = 0
+		if req.KeyAlgorithm == certs.ECDSAKey {
+			req.KeySize = 256
+		}
+	}
+	if instance.Spec.KeySize > 0 {
+		req.KeySize = instance.Spec.KeySize
+	}
+	return req
+}
+
+// duration returns the validity to request, from the spec or else the issuer or operator default
+func (r *ReconcileCertificate) duration(instance *certsv1alpha1.Certificate, iss issuer.Issuer) time.Duration {
+	if instance.Spec.Duration != nil {
+		return instance.Spec.Duration.Duration
+	}
+	return iss.CertDuration(r.config.General.CertDuration())
+}
+
+// renewAt returns when the current certificate is due for renewal
+func (r *ReconcileCertificate) renewAt(instance *certsv1alpha1.Certificate) time.Time {
+	renewal := r.config.General.Renewal
+	if instance.Spec.RenewBefore != nil {
+		renewal.Before = instance.Spec.RenewBefore.Duration.String()
+	}
+	return renewal.RenewAt(instance.Status.NotBefore.Time, instance.Status.NotAfter.Time)
+}
@@ -0,0 +1,336 @@
+package certificate
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	certsv1alpha1 "github.com/redhat-cop/cert-operator/pkg/apis/certs/v1alpha1"
+	"github.com/redhat-cop/cert-operator/pkg/certs"
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	"github.com/redhat-cop/cert-operator/pkg/helpers"
+	"github.com/redhat-cop/cert-operator/pkg/issuer"
+	"github.com/redhat-cop/cert-operator/pkg/notifier"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// revokingProvider issues self-signed certificates and remembers the ones it revoked
+type revokingProvider struct {
+	certs.SelfSignedProvider
+	revoked []string
+}
+
+func (p *revokingProvider) Deprovision(ctx context.Context, cert []byte) error {
+	p.revoked = append(p.revoked, string(cert))
+	return nil
+}
+
+// failingProvider refuses every request
+type failingProvider struct{}
+
+func (p *failingProvider) Provision(ctx context.Context, req certs.CertificateRequest) (certs.KeyPair, error) {
+	return certs.KeyPair{}, certs.NewCertError("request denied")
+}
+
+func (p *failingProvider) Deprovision(ctx context.Context, cert []byte) error {
+	return nil
+}
+
+// newTestReconciler returns a reconciler issuing self-signed certificates for objs
+func newTestReconciler(t *testing.T, objs ...runtime.Object) *ReconcileCertificate {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := certsv1alpha1.SchemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewFakeClientWithScheme(scheme, objs...)
+	config := certconf.DefaultConfig()
+
+	notifications, err := notifier.NewDispatcher(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &ReconcileCertificate{
+		client:        c,
+		scheme:        scheme,
+		config:        config,
+		issuers:       issuer.NewStaticResolver(c, config, issuer.Issuer{Provider: new(certs.SelfSignedProvider), Kind: "self-signed"}),
+		ctx:           context.TODO(),
+		recorder:      record.NewFakeRecorder(10),
+		notifications: notifications,
+	}
+}
+
+func newCertificate(spec certsv1alpha1.CertificateSpec) *certsv1alpha1.Certificate {
+	return &certsv1alpha1.Certificate{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "example",
+			Namespace: "test",
+		},
+		Spec: spec,
+	}
+}
+
+var request = reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "test", Name: "example"}}
+
+func TestReconcileIssues(t *testing.T) {
+	// setup
+	r := newTestReconciler(t, newCertificate(certsv1alpha1.CertificateSpec{
+		Hosts:      []string{"www.example.com"},
+		SecretName: "example-tls",
+	}))
+
+	// act
+	result, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := &corev1.Secret{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: "test", Name: "example-tls"}, secret); err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := certs.ParseCertificate(secret.Data["tls.crt"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.VerifyHostname("www.example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	instance := &certsv1alpha1.Certificate{}
+	if err := r.client.Get(context.TODO(), request.NamespacedName, instance); err != nil {
+		t.Fatal(err)
+	}
+	if ready := instance.Status.GetCondition(certsv1alpha1.CertificateReady); ready == nil || ready.Status != corev1.ConditionTrue {
+		t.Fatalf("certificate is not ready: %v", instance.Status.Conditions)
+	}
+	if instance.Status.NotAfter == nil || !instance.Status.NotAfter.Time.Equal(leaf.NotAfter) {
+		t.Fatal("expiry was not recorded")
+	}
+
+	if result.RequeueAfter < 365*24*time.Hour/2 {
+		t.Fatalf("expected renewal after two thirds of a year, got %s", result.RequeueAfter)
+	}
+}
+
+func TestReconcileInvalidRenewBefore(t *testing.T) {
+	// setup
+	r := newTestReconciler(t, newCertificate(certsv1alpha1.CertificateSpec{
+		Hosts:       []string{"www.example.com"},
+		SecretName:  "example-tls",
+		Duration:    &metav1.Duration{Duration: 24 * time.Hour},
+		RenewBefore: &metav1.Duration{Duration: 48 * time.Hour},
+	}))
+
+	// act
+	result, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Requeue || result.RequeueAfter > 0 {
+		t.Fatal("invalid spec was requeued")
+	}
+
+	instance := &certsv1alpha1.Certificate{}
+	if err := r.client.Get(context.TODO(), request.NamespacedName, instance); err != nil {
+		t.Fatal(err)
+	}
+	ready := instance.Status.GetCondition(certsv1alpha1.CertificateReady)
+	if ready == nil || ready.Status != corev1.ConditionFalse || ready.Reason != "InvalidSpec" {
+		t.Fatalf("expected an InvalidSpec condition, got %v", instance.Status.Conditions)
+	}
+
+	err = r.client.Get(context.TODO(), types.NamespacedName{Namespace: "test", Name: "example-tls"}, &corev1.Secret{})
+	if !errors.IsNotFound(err) {
+		t.Fatal("certificate was issued for an invalid spec")
+	}
+}
+
+func TestReconcileShortDuration(t *testing.T) {
+	// setup
+	r := newTestReconciler(t, newCertificate(certsv1alpha1.CertificateSpec{
+		Hosts:      []string{"www.example.com"},
+		SecretName: "example-tls",
+		Duration:   &metav1.Duration{Duration: time.Minute},
+	}))
+
+	// act
+	result, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RequeueAfter < certconf.MinRenewalDelay-time.Second {
+		t.Fatalf("expected a requeue after at least %s, got %s", certconf.MinRenewalDelay, result.RequeueAfter)
+	}
+
+	// the certificate is not renewed again right away
+	instance := &certsv1alpha1.Certificate{}
+	if err := r.client.Get(context.TODO(), request.NamespacedName, instance); err != nil {
+		t.Fatal(err)
+	}
+	serial := instance.Status.Serial
+
+	if _, err := r.Reconcile(request); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.client.Get(context.TODO(), request.NamespacedName, instance); err != nil {
+		t.Fatal(err)
+	}
+	if instance.Status.Serial != serial {
+		t.Fatal("certificate was renewed right after it was issued")
+	}
+}
+
+func TestReconcileSecretConflict(t *testing.T) {
+	// setup
+	foreign := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-credentials", Namespace: "test"},
+		Data:       map[string][]byte{"password": []byte("secret")},
+	}
+	r := newTestReconciler(t, foreign, newCertificate(certsv1alpha1.CertificateSpec{
+		Hosts:      []string{"www.example.com"},
+		SecretName: "db-credentials",
+	}))
+
+	// act
+	result, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Requeue || result.RequeueAfter > 0 {
+		t.Fatal("conflicting secret was requeued")
+	}
+
+	instance := &certsv1alpha1.Certificate{}
+	if err := r.client.Get(context.TODO(), request.NamespacedName, instance); err != nil {
+		t.Fatal(err)
+	}
+	ready := instance.Status.GetCondition(certsv1alpha1.CertificateReady)
+	if ready == nil || ready.Status != corev1.ConditionFalse || ready.Reason != "SecretConflict" {
+		t.Fatalf("expected a SecretConflict condition, got %v", instance.Status.Conditions)
+	}
+
+	secret := &corev1.Secret{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: "test", Name: "db-credentials"}, secret); err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data["password"]) != "secret" || len(secret.Data["tls.crt"]) > 0 {
+		t.Fatal("secret of someone else was overwritten")
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	// setup
+	keyPair, err := new(certs.SelfSignedProvider).Provision(context.TODO(), certs.NewCertificateRequest("www.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		owned   bool
+		revoked int
+	}{
+		{"owned secret", true, 1},
+		{"secret of someone else", false, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// setup
+			instance := newCertificate(certsv1alpha1.CertificateSpec{
+				Hosts:      []string{"www.example.com"},
+				SecretName: "example-tls",
+			})
+			helpers.AddFinalizer(instance, helpers.Finalizer)
+			now := metav1.Now()
+			instance.ObjectMeta.DeletionTimestamp = &now
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "example-tls", Namespace: "test"},
+				Data:       map[string][]byte{"tls.crt": keyPair.Cert},
+			}
+			if test.owned {
+				secret.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(instance, certsv1alpha1.SchemeGroupVersion.WithKind("Certificate"))}
+			}
+			provider := new(revokingProvider)
+			r := newTestReconciler(t, instance, secret)
+			r.issuers = issuer.NewStaticResolver(r.client, r.config, issuer.Issuer{Provider: provider, Kind: "test"})
+
+			// act
+			_, err := r.Reconcile(request)
+
+			// assert
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(provider.revoked) != test.revoked {
+				t.Fatalf("expected %d revoked certificates, got %d", test.revoked, len(provider.revoked))
+			}
+		})
+	}
+}
+
+// expectEvent fails t unless the next event recorded by r has reason
+func expectEvent(t *testing.T, r *ReconcileCertificate, reason string) {
+	select {
+	case event := <-r.recorder.(*record.FakeRecorder).Events:
+		if !strings.Contains(event, " "+reason+" ") {
+			t.Fatalf("expected a %s event, got %s", reason, event)
+		}
+	default:
+		t.Fatalf("expected a %s event, got none", reason)
+	}
+}
+
+func TestReconcileEvents(t *testing.T) {
+	// setup
+	r := newTestReconciler(t, newCertificate(certsv1alpha1.CertificateSpec{
+		Hosts:      []string{"www.example.com"},
+		SecretName: "example-tls",
+	}))
+
+	// act
+	if _, err := r.Reconcile(request); err != nil {
+		t.Fatal(err)
+	}
+
+	// assert
+	expectEvent(t, r, helpers.EventIssued)
+
+	// a certificate issued again replaces the one before
+	if err := r.client.Delete(context.TODO(), &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "example-tls", Namespace: "test"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(request); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, r, helpers.EventRenewed)
+
+	// a failure is recorded too
+	if err := r.client.Delete(context.TODO(), &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "example-tls", Namespace: "test"}}); err != nil {
+		t.Fatal(err)
+	}
+	r.issuers = issuer.NewStaticResolver(r.client, r.config, issuer.Issuer{Provider: new(failingProvider), Kind: "test"})
+	if _, err := r.Reconcile(request); err == nil {
+		t.Fatal("expected the failure to be retried")
+	}
+	expectEvent(t, r, helpers.EventIssueFailed)
+}
@@ -4,15 +4,18 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	"github.com/redhat-cop/cert-operator/pkg/issuer"
+	"github.com/redhat-cop/cert-operator/pkg/notifier"
 )
 
 // AddToManagerFuncs is a list of functions to add all Controllers to the Manager
-var AddToManagerFuncs []func(manager.Manager, certconf.Config) error
+var AddToManagerFuncs []func(manager.Manager, certconf.Config, *issuer.Resolver, *notifier.Dispatcher) error
 
-// AddToManager adds all Controllers to the Manager
-func AddToManager(m manager.Manager, c certconf.Config) error {
+// AddToManager adds all Controllers to the Manager. The controllers share issuers and notifications,
+// so providers and their caches are built once for the whole operator.
+func AddToManager(m manager.Manager, c certconf.Config, issuers *issuer.Resolver, notifications *notifier.Dispatcher) error {
 	for _, f := range AddToManagerFuncs {
-		if err := f(m, c); err != nil {
+		if err := f(m, c, issuers, notifications); err != nil {
 			return err
 		}
 	}
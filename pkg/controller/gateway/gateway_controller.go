@@ -0,0 +1,159 @@
+package gateway
+
+import (
+	"context"
+
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	"github.com/redhat-cop/cert-operator/pkg/helpers"
+	"github.com/redhat-cop/cert-operator/pkg/issuer"
+	"github.com/redhat-cop/cert-operator/pkg/metrics"
+	"github.com/redhat-cop/cert-operator/pkg/notifier"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var log = logf.Log.WithName("controller_gateway")
+
+// gatewayVersions are the Gateway API versions the controller can watch, most preferred first.
+// There are no Gateway API types for the vendored client-go, so gateways are read and written as
+// unstructured objects.
+var gatewayVersions = []string{"v1", "v1beta1"}
+
+const gatewayGroup = "gateway.networking.k8s.io"
+
+// Add creates a new Gateway Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started. Clusters without the Gateway API CRDs are skipped.
+func Add(mgr manager.Manager, config certconf.Config, issuers *issuer.Resolver, notifications *notifier.Dispatcher) error {
+	for _, version := range gatewayVersions {
+		gvk := schema.GroupVersionKind{Group: gatewayGroup, Version: version, Kind: "Gateway"}
+		_, err := mgr.GetRESTMapper().RESTMapping(gvk.GroupKind(), gvk.Version)
+		if meta.IsNoMatchError(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		return add(mgr, newReconciler(mgr, config, issuers, notifications, gvk), gvk)
+	}
+	log.Info("Cluster does not serve the Gateway API, not watching Gateways")
+	return nil
+}
+
+// newReconciler returns a new reconcile.Reconciler
+func newReconciler(mgr manager.Manager, config certconf.Config, issuers *issuer.Resolver,
+	notifications *notifier.Dispatcher, gvk schema.GroupVersionKind) reconcile.Reconciler {
+	ctx, err := helpers.ManagerContext(mgr)
+	if err != nil {
+		panic("There was a problem registering the shutdown handler. \n" +
+			"\t" + err.Error())
+	}
+
+	return &ReconcileGateway{client: mgr.GetClient(), config: config, gvk: gvk, tls: &helpers.TLSReconciler{
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		Config:        config,
+		Issuers:       issuers,
+		Ctx:           ctx,
+		Recorder:      mgr.GetRecorder("gateway-controller"),
+		Notifications: notifications,
+		Kind:          "Gateway",
+	}}
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, r reconcile.Reconciler, gvk schema.GroupVersionKind) error {
+	// Create a new controller
+	c, err := controller.New("gateway-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to primary resource Gateway
+	err = c.Watch(&source.Kind{Type: newGateway(gvk)}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to secondary resource Secrets and requeue the owner Gateway
+	err = c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    newGateway(gvk),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var _ reconcile.Reconciler = &ReconcileGateway{}
+
+// ReconcileGateway reconciles a Gateway object
+type ReconcileGateway struct {
+	// This client, initialized using mgr.Client() above, is a split client
+	// that reads objects from the cache and writes to the apiserver
+	client client.Client
+	config certconf.Config
+	// tls issues the certificates of the secrets the listeners reference
+	tls *helpers.TLSReconciler
+	// gvk is the version of the Gateway API that is watched
+	gvk schema.GroupVersionKind
+}
+
+// Reconcile issues a certificate for each Secret in the certificateRefs of the listeners of an
+// annotated Gateway that terminate TLS, covering the hostnames of the listeners referencing it.
+// Note:
+// The Controller will requeue the Request to be processed again if the returned error is non-nil or
+// Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
+func (r *ReconcileGateway) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	// Fetch the Gateway
+	gateway := newGateway(r.gvk)
+	err := r.client.Get(context.TODO(), request.NamespacedName, gateway)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+			// Return and don't requeue
+			metrics.DeleteExpiry("Gateway", request.Namespace, request.Name)
+			return reconcile.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		return reconcile.Result{}, err
+	}
+
+	listeners, err := tlsListeners(gateway)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	secrets, err := listenerSecrets(gateway.GetNamespace(), listeners)
+
+	if gateway.GetDeletionTimestamp() != nil {
+		return reconcile.Result{}, r.tls.Revoke(gateway, secrets)
+	}
+
+	// Look for annotation that requires action, otherwise skip it
+	annotations := gateway.GetAnnotations()
+	if annotations == nil || annotations[r.config.General.Annotations.Status] == "" {
+		// the annotation may have been removed from an object that had a certificate
+		metrics.DeleteExpiry("Gateway", request.Namespace, request.Name)
+		return reconcile.Result{}, nil
+	}
+
+	return r.tls.Reconcile(gateway, secrets, err)
+}
+
+func newGateway(gvk schema.GroupVersionKind) *unstructured.Unstructured {
+	gateway := &unstructured.Unstructured{}
+	gateway.SetGroupVersionKind(gvk)
+	return gateway
+}
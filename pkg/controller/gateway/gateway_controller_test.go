@@ -0,0 +1,197 @@
+package gateway
+
+import (
+	"context"
+	"testing"
+
+	"github.com/redhat-cop/cert-operator/pkg/certs"
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	"github.com/redhat-cop/cert-operator/pkg/helpers"
+	"github.com/redhat-cop/cert-operator/pkg/issuer"
+	"github.com/redhat-cop/cert-operator/pkg/notifier"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var gvk = schema.GroupVersionKind{Group: gatewayGroup, Version: "v1", Kind: "Gateway"}
+
+// revokingProvider issues self-signed certificates and remembers the ones it revoked
+type revokingProvider struct {
+	certs.SelfSignedProvider
+	revoked [][]byte
+}
+
+func (p *revokingProvider) Deprovision(ctx context.Context, cert []byte) error {
+	p.revoked = append(p.revoked, cert)
+	return nil
+}
+
+// newTestReconciler returns a reconciler issuing certificates with provider for objs
+func newTestReconciler(t *testing.T, provider certs.Provider, objs ...runtime.Object) *ReconcileGateway {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewFakeClientWithScheme(scheme, objs...)
+	config := certconf.DefaultConfig()
+
+	notifications, err := notifier.NewDispatcher(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &ReconcileGateway{client: c, config: config, gvk: gvk, tls: &helpers.TLSReconciler{
+		Client:        c,
+		Scheme:        scheme,
+		Config:        config,
+		Issuers:       issuer.NewStaticResolver(c, config, issuer.Issuer{Provider: provider, Kind: "test"}),
+		Ctx:           context.TODO(),
+		Recorder:      record.NewFakeRecorder(10),
+		Notifications: notifications,
+		Kind:          "Gateway",
+	}}
+}
+
+// newTestGateway returns an annotated Gateway with listeners
+func newTestGateway(config certconf.Config, listeners ...interface{}) *unstructured.Unstructured {
+	gateway := newGateway(gvk)
+	gateway.SetNamespace("test")
+	gateway.SetName("example")
+	gateway.SetAnnotations(map[string]string{config.General.Annotations.Status: config.General.Annotations.NeedCertValue})
+	unstructured.SetNestedSlice(gateway.Object, listeners, "spec", "listeners")
+	return gateway
+}
+
+// httpsListener returns an HTTPS listener for hostname terminating TLS with the certificate of
+// each of refs, which are Secret names or full certificateRefs
+func httpsListener(name string, hostname string, refs ...interface{}) map[string]interface{} {
+	var certificateRefs []interface{}
+	for _, ref := range refs {
+		if secret, ok := ref.(string); ok {
+			ref = map[string]interface{}{"name": secret}
+		}
+		certificateRefs = append(certificateRefs, ref)
+	}
+	return map[string]interface{}{
+		"name":     name,
+		"hostname": hostname,
+		"protocol": "HTTPS",
+		"tls":      map[string]interface{}{"mode": "Terminate", "certificateRefs": certificateRefs},
+	}
+}
+
+var request = reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "test", Name: "example"}}
+
+func getGateway(t *testing.T, r *ReconcileGateway) *unstructured.Unstructured {
+	gateway := newGateway(gvk)
+	if err := r.client.Get(context.TODO(), request.NamespacedName, gateway); err != nil {
+		t.Fatal(err)
+	}
+	return gateway
+}
+
+func TestReconcileCertificatePerSecret(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	gateway := newTestGateway(config,
+		httpsListener("a", "a.example.com", "a-tls"),
+		httpsListener("www-a", "www.a.example.com", "a-tls"),
+		httpsListener("b", "b.example.com", "b-tls"))
+	r := newTestReconciler(t, new(certs.SelfSignedProvider), gateway)
+
+	// act
+	_, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, hosts := range map[string][]string{"a-tls": {"a.example.com", "www.a.example.com"}, "b-tls": {"b.example.com"}} {
+		secret := &corev1.Secret{}
+		if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: "test", Name: name}, secret); err != nil {
+			t.Fatal(err)
+		}
+		cert := helpers.SecretCert(secret, false)
+		if cert == nil {
+			t.Fatal("no certificate in secret " + name)
+		}
+		for _, host := range hosts {
+			if err := cert.VerifyHostname(host); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(cert.DNSNames) != len(hosts) {
+			t.Fatalf("expected the certificate of %s to cover %v, got %v", name, hosts, cert.DNSNames)
+		}
+	}
+
+	if status := getGateway(t, r).GetAnnotations()[config.General.Annotations.Status]; status != "secured" {
+		t.Fatalf("expected status secured, got %s", status)
+	}
+}
+
+func TestReconcileForeignCertificateRef(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	gateway := newTestGateway(config,
+		httpsListener("a", "a.example.com", "a-tls"),
+		httpsListener("b", "b.example.com", map[string]interface{}{"name": "b-tls", "namespace": "other"}))
+	r := newTestReconciler(t, new(certs.SelfSignedProvider), gateway)
+
+	// act
+	_, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := getGateway(t, r).GetAnnotations()[config.General.Annotations.Status]; status != "failed" {
+		t.Fatalf("expected status failed, got %s", status)
+	}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Namespace: "test", Name: "a-tls"}, &corev1.Secret{})
+	if !errors.IsNotFound(err) {
+		t.Fatal("certificate was issued for a gateway that cannot be secured")
+	}
+}
+
+func TestReconcileRevokesOnDeletion(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	gateway := newTestGateway(config,
+		httpsListener("a", "a.example.com", "a-tls"),
+		httpsListener("b", "b.example.com", "b-tls"))
+	provider := new(revokingProvider)
+	r := newTestReconciler(t, provider, gateway)
+	if _, err := r.Reconcile(request); err != nil {
+		t.Fatal(err)
+	}
+	gateway = getGateway(t, r)
+	now := metav1.Now()
+	gateway.SetDeletionTimestamp(&now)
+	if err := r.client.Update(context.TODO(), gateway); err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	_, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provider.revoked) != 2 {
+		t.Fatalf("expected the certificate of each secret to be revoked, got %d", len(provider.revoked))
+	}
+	if helpers.HasFinalizer(getGateway(t, r), helpers.Finalizer) {
+		t.Fatal("finalizer was not removed")
+	}
+}
@@ -0,0 +1,116 @@
+package gateway
+
+import (
+	"github.com/redhat-cop/cert-operator/pkg/certs"
+	"github.com/redhat-cop/cert-operator/pkg/helpers"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// listener is an HTTPS or TLS listener of a Gateway that terminates TLS
+type listener struct {
+	Name            string
+	Hostname        string
+	CertificateRefs []certificateRef
+}
+
+// certificateRef is an entry of tls.certificateRefs of a listener
+type certificateRef struct {
+	Group     string
+	Kind      string
+	Namespace string
+	Name      string
+}
+
+// isLocalSecret reports whether ref is a Secret in namespace, the only kind of reference
+// the operator writes certificates to
+func (ref certificateRef) isLocalSecret(namespace string) bool {
+	return len(ref.Group) == 0 && (len(ref.Kind) == 0 || ref.Kind == "Secret") &&
+		(len(ref.Namespace) == 0 || ref.Namespace == namespace)
+}
+
+// tlsListeners returns the listeners of gateway that terminate TLS
+func tlsListeners(gateway *unstructured.Unstructured) ([]listener, error) {
+	items, _, err := unstructured.NestedSlice(gateway.Object, "spec", "listeners")
+	if err != nil {
+		return nil, err
+	}
+
+	var listeners []listener
+	for _, item := range items {
+		spec, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		protocol, _, _ := unstructured.NestedString(spec, "protocol")
+		mode, _, _ := unstructured.NestedString(spec, "tls", "mode")
+		if (protocol != "HTTPS" && protocol != "TLS") || (len(mode) > 0 && mode != "Terminate") {
+			continue
+		}
+
+		l := listener{}
+		l.Name, _, _ = unstructured.NestedString(spec, "name")
+		l.Hostname, _, _ = unstructured.NestedString(spec, "hostname")
+
+		refs, _, _ := unstructured.NestedSlice(spec, "tls", "certificateRefs")
+		for _, item := range refs {
+			ref, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			certRef := certificateRef{}
+			certRef.Group, _, _ = unstructured.NestedString(ref, "group")
+			certRef.Kind, _, _ = unstructured.NestedString(ref, "kind")
+			certRef.Namespace, _, _ = unstructured.NestedString(ref, "namespace")
+			certRef.Name, _, _ = unstructured.NestedString(ref, "name")
+			l.CertificateRefs = append(l.CertificateRefs, certRef)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// listenerSecrets returns the Secrets the listeners reference, each with the hostnames of the
+// listeners referencing it. Every listener needs a hostname to issue for and may only reference
+// Secrets in namespace, the operator cannot write anything else.
+func listenerSecrets(namespace string, listeners []listener) ([]helpers.TLSSecret, error) {
+	var secrets []helpers.TLSSecret
+	index := map[string]int{}
+	var err error
+	for _, l := range listeners {
+		if len(l.Hostname) == 0 {
+			err = certs.NewErrBadHost("listener " + l.Name + " has no hostname")
+		}
+		if len(l.CertificateRefs) == 0 {
+			err = certs.NewCertError("listener " + l.Name + " does not reference a Secret")
+		}
+		for _, ref := range l.CertificateRefs {
+			if !ref.isLocalSecret(namespace) {
+				err = certs.NewCertError("listener " + l.Name + " references " + ref.Kind + " " + ref.Namespace + "/" + ref.Name +
+					", only Secrets in the namespace of the Gateway are supported")
+				continue
+			}
+			i, ok := index[ref.Name]
+			if !ok {
+				i = len(secrets)
+				index[ref.Name] = i
+				secrets = append(secrets, helpers.TLSSecret{Name: ref.Name})
+			}
+			if len(l.Hostname) > 0 && !contains(secrets[i].Hosts, l.Hostname) {
+				secrets[i].Hosts = append(secrets[i].Hosts, l.Hostname)
+			}
+		}
+	}
+	if err == nil && len(secrets) == 0 {
+		err = certs.NewErrBadHost("Gateway has no HTTPS or TLS listeners that terminate TLS")
+	}
+	return secrets, err
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package ingress
+
+import (
+	"context"
+
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	"github.com/redhat-cop/cert-operator/pkg/helpers"
+	"github.com/redhat-cop/cert-operator/pkg/issuer"
+	"github.com/redhat-cop/cert-operator/pkg/metrics"
+	"github.com/redhat-cop/cert-operator/pkg/notifier"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var log = logf.Log.WithName("controller_ingress")
+
+// ingressGVK is the Ingress version that is watched. The vendored client-go predates the
+// networking.k8s.io/v1 types, so ingresses are read and written as unstructured objects.
+var ingressGVK = schema.GroupVersionKind{Group: "networking.k8s.io", Version: "v1", Kind: "Ingress"}
+
+// Add creates a new Ingress Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started. Clusters that do not serve networking.k8s.io/v1 Ingresses,
+// such as OpenShift 3.11, are skipped.
+func Add(mgr manager.Manager, config certconf.Config, issuers *issuer.Resolver, notifications *notifier.Dispatcher) error {
+	_, err := mgr.GetRESTMapper().RESTMapping(ingressGVK.GroupKind(), ingressGVK.Version)
+	if meta.IsNoMatchError(err) {
+		log.Info("Cluster does not serve networking.k8s.io/v1 Ingresses, not watching them")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return add(mgr, newReconciler(mgr, config, issuers, notifications))
+}
+
+// newReconciler returns a new reconcile.Reconciler
+func newReconciler(mgr manager.Manager, config certconf.Config, issuers *issuer.Resolver,
+	notifications *notifier.Dispatcher) reconcile.Reconciler {
+	ctx, err := helpers.ManagerContext(mgr)
+	if err != nil {
+		panic("There was a problem registering the shutdown handler. \n" +
+			"\t" + err.Error())
+	}
+
+	return &ReconcileIngress{client: mgr.GetClient(), config: config, tls: &helpers.TLSReconciler{
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		Config:        config,
+		Issuers:       issuers,
+		Ctx:           ctx,
+		Recorder:      mgr.GetRecorder("ingress-controller"),
+		Notifications: notifications,
+		Kind:          "Ingress",
+	}}
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, r reconcile.Reconciler) error {
+	// Create a new controller
+	c, err := controller.New("ingress-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to primary resource Ingress
+	err = c.Watch(&source.Kind{Type: newIngress()}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to secondary resource Secrets and requeue the owner Ingress
+	err = c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    newIngress(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var _ reconcile.Reconciler = &ReconcileIngress{}
+
+// ReconcileIngress reconciles an Ingress object
+type ReconcileIngress struct {
+	// This client, initialized using mgr.Client() above, is a split client
+	// that reads objects from the cache and writes to the apiserver
+	client client.Client
+	config certconf.Config
+	// tls issues the certificates of the secrets in spec.tls
+	tls *helpers.TLSReconciler
+}
+
+// Reconcile issues a certificate for each Secret spec.tls of an annotated Ingress references,
+// covering the hosts of the entries naming that Secret.
+// Note:
+// The Controller will requeue the Request to be processed again if the returned error is non-nil or
+// Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
+func (r *ReconcileIngress) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	// Fetch the Ingress
+	ingress := newIngress()
+	err := r.client.Get(context.TODO(), request.NamespacedName, ingress)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+			// Return and don't requeue
+			metrics.DeleteExpiry("Ingress", request.Namespace, request.Name)
+			return reconcile.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		return reconcile.Result{}, err
+	}
+
+	tls, err := tlsEntries(ingress)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	secrets, err := tlsSecrets(tls)
+
+	if ingress.GetDeletionTimestamp() != nil {
+		return reconcile.Result{}, r.tls.Revoke(ingress, secrets)
+	}
+
+	// Look for annotation that requires action, otherwise skip it
+	annotations := ingress.GetAnnotations()
+	if annotations == nil || annotations[r.config.General.Annotations.Status] == "" {
+		// the annotation may have been removed from an object that had a certificate
+		metrics.DeleteExpiry("Ingress", request.Namespace, request.Name)
+		return reconcile.Result{}, nil
+	}
+
+	return r.tls.Reconcile(ingress, secrets, err)
+}
+
+func newIngress() *unstructured.Unstructured {
+	ingress := &unstructured.Unstructured{}
+	ingress.SetGroupVersionKind(ingressGVK)
+	return ingress
+}
@@ -0,0 +1,234 @@
+package ingress
+
+import (
+	"context"
+	"crypto/x509"
+	"testing"
+
+	"github.com/redhat-cop/cert-operator/pkg/certs"
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	"github.com/redhat-cop/cert-operator/pkg/helpers"
+	"github.com/redhat-cop/cert-operator/pkg/issuer"
+	"github.com/redhat-cop/cert-operator/pkg/notifier"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// asyncProvider issues self-signed certificates once they have been asked for twice
+type asyncProvider struct {
+	certs.SelfSignedProvider
+	requests map[string]certs.CertificateRequest
+	polls    map[string]int
+}
+
+func (p *asyncProvider) Submit(ctx context.Context, req certs.CertificateRequest) (certs.PendingRequest, error) {
+	id := req.CommonName()
+	p.requests[id] = req
+	return certs.PendingRequest{ID: id, Key: []byte("key")}, nil
+}
+
+func (p *asyncProvider) Retrieve(ctx context.Context, pending certs.PendingRequest) (certs.KeyPair, error) {
+	p.polls[pending.ID]++
+	if p.polls[pending.ID] < 2 {
+		return certs.KeyPair{}, certs.NewErrCertificatePending("not approved yet")
+	}
+	return p.Provision(ctx, p.requests[pending.ID])
+}
+
+// newTestReconciler returns a reconciler issuing certificates with provider for objs
+func newTestReconciler(t *testing.T, provider certs.Provider, objs ...runtime.Object) *ReconcileIngress {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewFakeClientWithScheme(scheme, objs...)
+	config := certconf.DefaultConfig()
+
+	notifications, err := notifier.NewDispatcher(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &ReconcileIngress{client: c, config: config, tls: &helpers.TLSReconciler{
+		Client:        c,
+		Scheme:        scheme,
+		Config:        config,
+		Issuers:       issuer.NewStaticResolver(c, config, issuer.Issuer{Provider: provider, Kind: "test"}),
+		Ctx:           context.TODO(),
+		Recorder:      record.NewFakeRecorder(10),
+		Notifications: notifications,
+		Kind:          "Ingress",
+	}}
+}
+
+// newTestIngress returns an annotated Ingress with a spec.tls entry for each of tls
+func newTestIngress(config certconf.Config, tls ...map[string]interface{}) *unstructured.Unstructured {
+	var entries []interface{}
+	for _, entry := range tls {
+		entries = append(entries, entry)
+	}
+	ingress := newIngress()
+	ingress.SetNamespace("test")
+	ingress.SetName("example")
+	ingress.SetAnnotations(map[string]string{config.General.Annotations.Status: config.General.Annotations.NeedCertValue})
+	unstructured.SetNestedSlice(ingress.Object, entries, "spec", "tls")
+	return ingress
+}
+
+func tlsEntry(secretName string, hosts ...interface{}) map[string]interface{} {
+	return map[string]interface{}{"secretName": secretName, "hosts": hosts}
+}
+
+var request = reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "test", Name: "example"}}
+
+// secretCert returns the certificate in the Secret name
+func secretCert(t *testing.T, r *ReconcileIngress, name string) *x509.Certificate {
+	secret := &corev1.Secret{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: "test", Name: name}, secret); err != nil {
+		t.Fatal(err)
+	}
+	cert := helpers.SecretCert(secret, false)
+	if cert == nil {
+		t.Fatal("no certificate in secret " + name)
+	}
+	return cert
+}
+
+func getIngress(t *testing.T, r *ReconcileIngress) *unstructured.Unstructured {
+	ingress := newIngress()
+	if err := r.client.Get(context.TODO(), request.NamespacedName, ingress); err != nil {
+		t.Fatal(err)
+	}
+	return ingress
+}
+
+func TestReconcileCertificatePerEntry(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	ingress := newTestIngress(config,
+		tlsEntry("a-tls", "a.example.com"),
+		tlsEntry("b-tls", "b.example.com", "www.b.example.com"))
+	r := newTestReconciler(t, new(certs.SelfSignedProvider), ingress)
+
+	// act
+	_, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := secretCert(t, r, "a-tls")
+	if a.VerifyHostname("a.example.com") != nil || a.VerifyHostname("b.example.com") == nil {
+		t.Fatalf("expected the certificate of a-tls to cover only its own entry, got %v", a.DNSNames)
+	}
+	b := secretCert(t, r, "b-tls")
+	if b.VerifyHostname("www.b.example.com") != nil || b.VerifyHostname("a.example.com") == nil {
+		t.Fatalf("expected the certificate of b-tls to cover only its own entry, got %v", b.DNSNames)
+	}
+
+	ingress = getIngress(t, r)
+	if status := ingress.GetAnnotations()[config.General.Annotations.Status]; status != "secured" {
+		t.Fatalf("expected status secured, got %s", status)
+	}
+	if !helpers.HasFinalizer(ingress, helpers.Finalizer) {
+		t.Fatal("finalizer was not added")
+	}
+}
+
+func TestReconcileReissuesOnlyModifiedSecret(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	ingress := newTestIngress(config,
+		tlsEntry("a-tls", "a.example.com"),
+		tlsEntry("b-tls", "b.example.com"))
+	r := newTestReconciler(t, new(certs.SelfSignedProvider), ingress)
+	if _, err := r.Reconcile(request); err != nil {
+		t.Fatal(err)
+	}
+	a := secretCert(t, r, "a-tls")
+	b := secretCert(t, r, "b-tls")
+	if err := r.client.Delete(context.TODO(), &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "b-tls"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	_, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !secretCert(t, r, "a-tls").Equal(a) {
+		t.Fatal("certificate of an intact secret was replaced")
+	}
+	if secretCert(t, r, "b-tls").Equal(b) {
+		t.Fatal("certificate of the deleted secret was not issued again")
+	}
+}
+
+func TestReconcileEntryWithoutSecret(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	ingress := newTestIngress(config,
+		tlsEntry("a-tls", "a.example.com"),
+		map[string]interface{}{"hosts": []interface{}{"b.example.com"}})
+	r := newTestReconciler(t, new(certs.SelfSignedProvider), ingress)
+
+	// act
+	_, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingress = getIngress(t, r)
+	if status := ingress.GetAnnotations()[config.General.Annotations.Status]; status != "failed" {
+		t.Fatalf("expected status failed, got %s", status)
+	}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Namespace: "test", Name: "a-tls"}, &corev1.Secret{})
+	if !errors.IsNotFound(err) {
+		t.Fatal("certificate was issued for an ingress that cannot be secured")
+	}
+}
+
+func TestReconcilePendingEntries(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	ingress := newTestIngress(config,
+		tlsEntry("a-tls", "a.example.com"),
+		tlsEntry("b-tls", "b.example.com"))
+	provider := &asyncProvider{requests: map[string]certs.CertificateRequest{}, polls: map[string]int{}}
+	r := newTestReconciler(t, provider, ingress)
+
+	// act
+	var statuses []string
+	for i := 0; i < 3; i++ {
+		if _, err := r.Reconcile(request); err != nil {
+			t.Fatal(err)
+		}
+		statuses = append(statuses, getIngress(t, r).GetAnnotations()[config.General.Annotations.Status])
+	}
+
+	// assert
+	if statuses[0] != helpers.StatusPending || statuses[1] != helpers.StatusPending || statuses[2] != "secured" {
+		t.Fatalf("expected the ingress to be pending until both certificates are issued, got %v", statuses)
+	}
+	if len(provider.requests) != 2 {
+		t.Fatalf("expected a request per secret, got %d", len(provider.requests))
+	}
+	if secretCert(t, r, "b-tls").VerifyHostname("b.example.com") != nil {
+		t.Fatal("certificate of b-tls does not cover its host")
+	}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: "test", Name: "a-tls-pending-key"}, &corev1.Secret{})
+	if !errors.IsNotFound(err) {
+		t.Fatal("pending key was not removed")
+	}
+}
@@ -0,0 +1,76 @@
+package ingress
+
+import (
+	"strings"
+
+	"github.com/redhat-cop/cert-operator/pkg/certs"
+	"github.com/redhat-cop/cert-operator/pkg/helpers"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ingressTLS is an entry of spec.tls of an Ingress
+type ingressTLS struct {
+	Hosts      []string
+	SecretName string
+}
+
+// tlsEntries returns spec.tls of ingress
+func tlsEntries(ingress *unstructured.Unstructured) ([]ingressTLS, error) {
+	items, _, err := unstructured.NestedSlice(ingress.Object, "spec", "tls")
+	if err != nil {
+		return nil, err
+	}
+
+	var tls []ingressTLS
+	for _, item := range items {
+		entry, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		hosts, _, _ := unstructured.NestedStringSlice(entry, "hosts")
+		secretName, _, _ := unstructured.NestedString(entry, "secretName")
+		tls = append(tls, ingressTLS{Hosts: hosts, SecretName: secretName})
+	}
+	return tls, nil
+}
+
+// tlsSecrets returns the secrets of all entries, each with the hosts of the entries naming it.
+// Every entry needs a secret to write the certificate to and hosts to issue it for.
+func tlsSecrets(tls []ingressTLS) ([]helpers.TLSSecret, error) {
+	var secrets []helpers.TLSSecret
+	index := map[string]int{}
+	var err error
+	for _, entry := range tls {
+		if len(entry.SecretName) == 0 {
+			err = certs.NewCertError("spec.tls entry for [" + strings.Join(entry.Hosts, ", ") + "] does not name a secret")
+			continue
+		}
+		if len(entry.Hosts) == 0 {
+			err = certs.NewErrBadHost("spec.tls entry for secret " + entry.SecretName + " has no hosts")
+		}
+		i, ok := index[entry.SecretName]
+		if !ok {
+			i = len(secrets)
+			index[entry.SecretName] = i
+			secrets = append(secrets, helpers.TLSSecret{Name: entry.SecretName})
+		}
+		for _, host := range entry.Hosts {
+			if !contains(secrets[i].Hosts, host) {
+				secrets[i].Hosts = append(secrets[i].Hosts, host)
+			}
+		}
+	}
+	if err == nil && len(secrets) == 0 {
+		err = certs.NewErrBadHost("Ingress has no hosts in spec.tls")
+	}
+	return secrets, err
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package route
+
+import (
+	"context"
+
+	routev1 "github.com/openshift/api/route/v1"
+	"github.com/redhat-cop/cert-operator/pkg/certs"
+	"github.com/redhat-cop/cert-operator/pkg/helpers"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// isPassthrough reports whether the backend of route terminates TLS itself
+func isPassthrough(route *routev1.Route) bool {
+	return route.Spec.TLS != nil && route.Spec.TLS.Termination == routev1.TLSTerminationPassthrough
+}
+
+// passthroughSecretName returns the name of the Secret the certificate of a passthrough route is
+// written to, as named by the passthrough-secret annotation or <name>-route-certificate
+func (r *ReconcileRoute) passthroughSecretName(route *routev1.Route) string {
+	if name := route.ObjectMeta.Annotations[r.config.General.Annotations.PassthroughSecret]; len(name) > 0 {
+		return name
+	}
+	return route.Name + "-route-certificate"
+}
+
+// passthroughPKCS12 reports whether the certificate of a passthrough route is stored as PKCS12
+func (r *ReconcileRoute) passthroughPKCS12(route *routev1.Route) bool {
+	return route.ObjectMeta.Annotations[r.config.General.Annotations.Format] == r.config.General.Annotations.Pkcs12Format
+}
+
+// writeSecret writes keyPair to the Secret of a passthrough route, owned by the route
+func (r *ReconcileRoute) writeSecret(route *routev1.Route, keyPair certs.KeyPair) error {
+	dm, secretType, err := helpers.SecretData(keyPair, r.passthroughPKCS12(route))
+	if err != nil {
+		return err
+	}
+
+	certSec := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      r.passthroughSecretName(route),
+			Namespace: route.Namespace,
+		},
+		Data: dm,
+		Type: secretType,
+	}
+	// owned by the route, so it is garbage collected with it and changes to it are noticed
+	err = controllerutil.SetControllerReference(route, certSec, r.scheme)
+	if err != nil {
+		return err
+	}
+
+	return helpers.Apply(r.client, certSec)
+}
+
+// passthroughSecretFree reports whether the certificate of a passthrough route may be written to
+// its Secret. The annotation can name any Secret, so only one that does not exist yet or that
+// the route owns is written to.
+func (r *ReconcileRoute) passthroughSecretFree(route *routev1.Route) (bool, error) {
+	secret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: route.Namespace, Name: r.passthroughSecretName(route)}, secret)
+	if errors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return metav1.IsControlledBy(secret, route), nil
+}
+
+// passthroughCert returns the PEM certificate held by the Secret of a passthrough route, or nil
+// when there is no such Secret or the route does not own it
+func (r *ReconcileRoute) passthroughCert(route *routev1.Route) ([]byte, error) {
+	secret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: route.Namespace, Name: r.passthroughSecretName(route)}, secret)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !metav1.IsControlledBy(secret, route) {
+		return nil, nil
+	}
+	return secret.Data["tls.crt"], nil
+}
+
+// secretIntact reports whether the Secret of a passthrough route still holds the certificate
+// recorded in its expiry annotation
+func (r *ReconcileRoute) secretIntact(route *routev1.Route) (bool, error) {
+	secret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: route.Namespace, Name: r.passthroughSecretName(route)}, secret)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	cert := helpers.SecretCert(secret, r.passthroughPKCS12(route))
+	if cert == nil {
+		return false, nil
+	}
+	return cert.NotAfter.UTC().Format(helpers.TimeFormat) == route.ObjectMeta.Annotations[r.config.General.Annotations.Expiry], nil
+}
@@ -0,0 +1,116 @@
+package route
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	routev1 "github.com/openshift/api/route/v1"
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	"github.com/redhat-cop/cert-operator/pkg/helpers"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newPassthroughRoute(config certconf.Config, secret string) *routev1.Route {
+	route := newTestRoute(config)
+	route.ObjectMeta.Annotations[config.General.Annotations.PassthroughSecret] = secret
+	route.Spec.TLS = &routev1.TLSConfig{Termination: routev1.TLSTerminationPassthrough}
+	return route
+}
+
+// foreignSecret returns a Secret no route owns
+func foreignSecret() *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-credentials", Namespace: "test"},
+		Data: map[string][]byte{
+			"tls.crt":  []byte("certificate of someone else"),
+			"password": []byte("secret"),
+		},
+	}
+}
+
+func getSecret(t *testing.T, r *ReconcileRoute, name string) *corev1.Secret {
+	secret := &corev1.Secret{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: "test", Name: name}, secret); err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+func TestReconcilePassthroughWritesSecret(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	r, patched := newTestReconciler(t, new(revokingProvider), newPassthroughRoute(config, "example-tls"))
+
+	// act
+	_, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := getSecret(t, r, "example-tls")
+	cert := helpers.SecretCert(secret, false)
+	if cert == nil {
+		t.Fatal("no certificate in the secret")
+	}
+	if err := cert.VerifyHostname("www.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(secret.OwnerReferences) != 1 || secret.OwnerReferences[0].Name != "example" {
+		t.Fatal("secret is not owned by the route")
+	}
+	if len(patched.route.Spec.TLS.Certificate) > 0 {
+		t.Fatal("certificate was set on a passthrough route")
+	}
+	if status := patched.route.ObjectMeta.Annotations[config.General.Annotations.Status]; status != "secured" {
+		t.Fatalf("expected status secured, got %s", status)
+	}
+}
+
+func TestReconcilePassthroughRefusesForeignSecret(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	r, patched := newTestReconciler(t, new(revokingProvider), newPassthroughRoute(config, "db-credentials"), foreignSecret())
+
+	// act
+	_, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := patched.route.ObjectMeta.Annotations[config.General.Annotations.Status]; status != "failed" {
+		t.Fatalf("expected status failed, got %s", status)
+	}
+	if secret := getSecret(t, r, "db-credentials"); string(secret.Data["password"]) != "secret" {
+		t.Fatal("secret of someone else was overwritten")
+	}
+}
+
+func TestRevokeSkipsForeignSecret(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	route := newPassthroughRoute(config, "db-credentials")
+	helpers.SetIssued(config, route, time.Now().Add(time.Hour))
+	helpers.AddFinalizer(route, helpers.Finalizer)
+	now := metav1.Now()
+	route.ObjectMeta.DeletionTimestamp = &now
+	secret, _ := newSourceSecret(t, "www.example.com", time.Hour)
+	secret.ObjectMeta.Name = "db-credentials"
+	provider := new(revokingProvider)
+	r, _ := newTestReconciler(t, provider, route, secret)
+
+	// act
+	_, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provider.revoked) > 0 {
+		t.Fatal("certificate in a secret the route does not own was revoked")
+	}
+}
@@ -2,17 +2,27 @@ package route
 
 import (
 	"context"
+	"encoding/json"
+	"reflect"
+	"time"
 
+	jsonpatch "github.com/evanphx/json-patch"
 	routev1 "github.com/openshift/api/route/v1"
 	v1 "github.com/openshift/api/route/v1"
 	"github.com/redhat-cop/cert-operator/pkg/certs"
 	certconf "github.com/redhat-cop/cert-operator/pkg/config"
 	"github.com/redhat-cop/cert-operator/pkg/helpers"
+	"github.com/redhat-cop/cert-operator/pkg/issuer"
+	"github.com/redhat-cop/cert-operator/pkg/metrics"
+	"github.com/redhat-cop/cert-operator/pkg/notifier"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -31,55 +41,68 @@ var log = logf.Log.WithName("controller_route")
 
 // Add creates a new Route Controller and adds it to the Manager. The Manager will set fields on the Controller
 // and Start it when the Manager is Started.
-func Add(mgr manager.Manager, config certconf.Config) error {
-	return add(mgr, newReconciler(mgr, config))
+func Add(mgr manager.Manager, config certconf.Config, issuers *issuer.Resolver, notifications *notifier.Dispatcher) error {
+	return add(mgr, newReconciler(mgr, config, issuers, notifications), config)
 }
 
 // newReconciler returns a new reconcile.Reconciler
-func newReconciler(mgr manager.Manager, config certconf.Config) reconcile.Reconciler {
-	var provider certs.Provider
-
-	if config.Provider.Ssl == "true" {
-		// logrus.Infof("SSL Verified")
-		log.Info("SSL Verified")
-	} else {
-		// logrus.Infof("SSL Not Verified")
-		log.Info("SSL Not Verified")
-	}
-
-	switch config.Provider.Kind {
-	case "none":
-		// logrus.Infof("None provider.")
-		log.Info("None provider.")
-		provider = new(certs.NoneProvider)
-	case "self-signed":
-		// logrus.Infof("Self Signed provider.")
-		log.Info("Self Signed provider.")
-		provider = new(certs.SelfSignedProvider)
-	case "venafi":
-		// logrus.Infof("Venafi Cert provider.")
-		provider = new(certs.VenafiProvider)
-	default:
-		panic("There was a problem detecting which provider to configure. \n" +
-			"\tProvider kind `" + config.Provider.Kind + "` is invalid. \n" +
-			config.String())
-	}
-
-	return &ReconcileRoute{client: mgr.GetClient(), scheme: mgr.GetScheme(), config: config, provider: provider}
+func newReconciler(mgr manager.Manager, config certconf.Config, issuers *issuer.Resolver,
+	notifications *notifier.Dispatcher) reconcile.Reconciler {
+	dynamicClient, err := dynamic.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		panic("There was a problem creating the dynamic client. \n" +
+			"\t" + err.Error())
+	}
+
+	ctx, err := helpers.ManagerContext(mgr)
+	if err != nil {
+		panic("There was a problem registering the shutdown handler. \n" +
+			"\t" + err.Error())
+	}
+
+	return &ReconcileRoute{client: mgr.GetClient(), scheme: mgr.GetScheme(), config: config, issuers: issuers, ctx: ctx,
+		recorder: mgr.GetRecorder("route-controller"), notifications: notifications, dynamic: dynamicClient}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
-func add(mgr manager.Manager, r reconcile.Reconciler) error {
+func add(mgr manager.Manager, r reconcile.Reconciler, config certconf.Config) error {
+	c, err := newController(mgr, r)
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to Services and requeue the reencrypt Routes that point to them, so the
+	// destination CA follows the certificate of the service
+	err = c.Watch(&source.Kind{Type: &corev1.Service{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(a handler.MapObject) []reconcile.Request {
+			return routesForService(mgr.GetClient(), a.Meta.GetNamespace(), a.Meta.GetName())
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to Secrets and requeue the Routes that sync them, so rotated and
+	// shared certificates reach the routes
+	return c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(a handler.MapObject) []reconcile.Request {
+			return routesForSecret(mgr.GetClient(), config.General.Annotations, a.Meta.GetNamespace(), a.Meta.GetName())
+		}),
+	})
+}
+
+// newController creates the controller and watches Routes and the Secrets they own
+func newController(mgr manager.Manager, r reconcile.Reconciler) (controller.Controller, error) {
 	// Create a new controller
 	c, err := controller.New("route-controller", mgr, controller.Options{Reconciler: r})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Watch for changes to primary resource Route
 	err = c.Watch(&source.Kind{Type: &routev1.Route{}}, &handler.EnqueueRequestForObject{})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Watch for changes to secondary resource Pods and requeue the owner Route
@@ -88,10 +111,38 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		OwnerType:    &routev1.Route{},
 	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return c, nil
+}
+
+// routesForService returns requests for the reencrypt routes in namespace that send traffic to the service
+func routesForService(c client.Client, namespace string, service string) []reconcile.Request {
+	routes := &routev1.RouteList{}
+	if err := c.List(context.TODO(), &client.ListOptions{Namespace: namespace}, routes); err != nil {
+		log.Error(err, "Failed to list routes of service", "Namespace", namespace, "Service", service)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, route := range routes.Items {
+		if route.Spec.TLS == nil || route.Spec.TLS.Termination != v1.TLSTerminationReencrypt {
+			continue
+		}
+		if routeService(&route) == service {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: route.Namespace, Name: route.Name}})
+		}
+	}
+	return requests
+}
+
+// routeService returns the name of the service route sends traffic to, or "" if it is not a service
+func routeService(route *routev1.Route) string {
+	if len(route.Spec.To.Kind) > 0 && route.Spec.To.Kind != "Service" {
+		return ""
+	}
+	return route.Spec.To.Name
 }
 
 var _ reconcile.Reconciler = &ReconcileRoute{}
@@ -100,10 +151,16 @@ var _ reconcile.Reconciler = &ReconcileRoute{}
 type ReconcileRoute struct {
 	// This client, initialized using mgr.Client() above, is a split client
 	// that reads objects from the cache and writes to the apiserver
-	client   client.Client
-	scheme   *runtime.Scheme
-	config   certconf.Config
-	provider certs.Provider
+	client  client.Client
+	scheme  *runtime.Scheme
+	config  certconf.Config
+	issuers *issuer.Resolver
+	// ctx is cancelled when the manager stops, abandoning in-flight provider calls
+	ctx           context.Context
+	recorder      record.EventRecorder
+	notifications *notifier.Dispatcher
+	// dynamic patches routes, the split client cannot
+	dynamic dynamic.Interface
 }
 
 // Reconcile reads that state of the cluster for a Route object and makes changes based on the state read
@@ -122,17 +179,78 @@ func (r *ReconcileRoute) Reconcile(request reconcile.Request) (reconcile.Result,
 			// Request object not found, could have been deleted after reconcile request.
 			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
 			// Return and don't requeue
+			metrics.DeleteExpiry("Route", request.Namespace, request.Name)
 			return reconcile.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
 		return reconcile.Result{}, err
 	}
+	// changes are sent as a patch against what was read
+	original := route.DeepCopy()
+
+	if route.GetDeletionTimestamp() != nil {
+		return reconcile.Result{}, r.revoke(route)
+	}
+
+	if name := route.ObjectMeta.Annotations[r.config.General.Annotations.SourceSecret]; len(name) > 0 {
+		return r.syncSource(route, original, name)
+	}
+	if name := route.ObjectMeta.Annotations[r.config.General.Annotations.SharedCertificate]; len(name) > 0 {
+		return reconcile.Result{}, r.syncShared(route, original, name)
+	}
 
 	if route.ObjectMeta.Annotations == nil || route.ObjectMeta.Annotations[r.config.General.Annotations.Status] == "" {
+		// the annotation may have been removed from an object that had a certificate
+		metrics.DeleteExpiry("Route", request.Namespace, request.Name)
 		return reconcile.Result{}, nil
 	}
 
-	if route.ObjectMeta.Annotations[r.config.General.Annotations.Status] == r.config.General.Annotations.NeedCertValue {
+	status := route.ObjectMeta.Annotations[r.config.General.Annotations.Status]
+	if status == "secured" && route.Spec.TLS != nil && route.Spec.TLS.Termination == v1.TLSTerminationReencrypt {
+		destinationCA, err := r.destinationCA(route)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if destinationCA != route.Spec.TLS.DestinationCACertificate {
+			route.Spec.TLS.DestinationCACertificate = destinationCA
+			if err := r.patch(original, route); err != nil {
+				return reconcile.Result{}, err
+			}
+			// the update brings the route back for the renewal check
+			reqLogger.Info("Updated destination CA of route")
+			return reconcile.Result{}, nil
+		}
+	}
+	if expiry, err := helpers.Expiry(r.config, route); err == nil {
+		metrics.SetExpiry("Route", route.Namespace, route.Name, expiry)
+	}
+	if status == "secured" {
+		intact := true
+		if isPassthrough(route) {
+			intact, err = r.secretIntact(route)
+			if err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+
+		renewAt, err := helpers.NextRenewal(r.config, route)
+		if !intact {
+			reqLogger.Info("Certificate secret is missing or was modified, issuing a new certificate")
+		} else if helpers.HostsChanged(r.config, route, []string{route.Spec.Host}) {
+			reqLogger.Info("Route host changed, issuing a new certificate")
+		} else if err != nil {
+			reqLogger.Error(err, "Cannot read certificate expiry, renewing now")
+		} else if wait := time.Until(renewAt); wait > 0 {
+			return reconcile.Result{RequeueAfter: wait}, nil
+		} else {
+			reqLogger.Info("Renewing certificate")
+		}
+	}
+
+	// a certificate that is being renewed stays in place until its successor is issued
+	renewing := status != r.config.General.Annotations.NeedCertValue && len(route.ObjectMeta.Annotations[r.config.General.Annotations.Expiry]) > 0
+
+	if status == r.config.General.Annotations.NeedCertValue || status == helpers.StatusPending || status == "secured" {
 		reqLogger.Info("Reconciling Route")
 
 		var termination v1.TLSTerminationType
@@ -143,41 +261,233 @@ func (r *ReconcileRoute) Reconcile(request reconcile.Request) (reconcile.Result,
 			termination = route.Spec.TLS.Termination
 		}
 
-		if termination == v1.TLSTerminationPassthrough {
-			route.ObjectMeta.Annotations[r.config.General.Annotations.Status] = "failed"
-			route.ObjectMeta.Annotations[r.config.General.Annotations.StatusReason] = "Certificate and key cannot be set on Passthrough route"
+		// Retrieve cert from provider
+		certReq := certs.NewCertificateRequest(route.Spec.Host)
+		certReq.Options[certs.OptionNamespace] = route.Namespace
 
-			err = helpers.Apply(r.client, route)
+		var keyPair certs.KeyPair
+		issued := false
+		failure := helpers.EventIssueFailed
+		iss, err := r.issuers.ForObject(r.ctx, route)
+		if err != nil {
+			failure = helpers.EventProviderUnavailable
+		} else if termination == v1.TLSTerminationPassthrough {
+			free, freeErr := r.passthroughSecretFree(route)
+			if freeErr != nil {
+				return reconcile.Result{}, freeErr
+			}
+			if !free {
+				err = certs.NewCertError("secret " + r.passthroughSecretName(route) + " exists and is not owned by the route")
+			}
+		}
+		if err == nil {
+			certReq.Duration = iss.CertDuration(r.config.General.CertDuration())
+			keyPair, issued, err = helpers.ObtainCert(r.ctx, r.client, r.scheme, r.config, route, certReq, iss.Provider, route.Name+"-route-pending-key")
+			metrics.ObserveIssue(iss.Kind, route.Namespace, issued, err)
+		}
+		if r.ctx.Err() != nil {
+			// shutting down, leave the status alone so the next leader picks it up
+			return reconcile.Result{}, r.ctx.Err()
+		}
+		if err == nil && !issued {
+			// the CA has not issued the certificate yet, collect it on a later reconcile
+			route.ObjectMeta.Annotations[r.config.General.Annotations.Status] = helpers.StatusPending
+			err = r.patch(original, route)
+			if err != nil {
+				return reconcile.Result{}, err
+			}
+			reqLogger.Info("Waiting for certificate to be issued")
+			return reconcile.Result{RequeueAfter: r.config.General.PollDuration()}, nil
+		}
+		if err != nil {
+			r.recorder.Event(route, corev1.EventTypeWarning, failure, err.Error())
+			expiry, _ := helpers.Expiry(r.config, route)
+			helpers.NotifyFailed(r.ctx, r.notifications, r.config, "Route", route, certReq.Hosts(), expiry, err)
+		}
+		if err != nil && renewing {
+			// keep serving the current certificate and retry with backoff
+			route.ObjectMeta.Annotations[r.config.General.Annotations.Status] = "secured"
+			route.ObjectMeta.Annotations[r.config.General.Annotations.StatusReason] = "Renewal failed: " + err.Error()
+			if applyErr := r.patch(original, route); applyErr != nil {
+				return reconcile.Result{}, applyErr
+			}
 			return reconcile.Result{}, err
 		}
-
-		// Retrieve cert from provider
-		keyPair, err := helpers.GetCert(route.Spec.Host, r.provider, r.config.Provider.Ssl)
 		if err != nil {
 			route.ObjectMeta.Annotations[r.config.General.Annotations.Status] = "failed"
 			route.ObjectMeta.Annotations[r.config.General.Annotations.StatusReason] = err.Error()
 		} else {
 			route.ObjectMeta.Annotations[r.config.General.Annotations.Status] = "secured"
-			route.ObjectMeta.Annotations[r.config.General.Annotations.Expiry] = keyPair.Expiry.Format(helpers.TimeFormat)
+			delete(route.ObjectMeta.Annotations, r.config.General.Annotations.StatusReason)
+			helpers.SetIssued(r.config, route, keyPair.Expiry)
+			helpers.SetHosts(r.config, route, certReq.Hosts())
+			helpers.AddFinalizer(route, helpers.Finalizer)
 		}
 
-		route.Spec.TLS = &v1.TLSConfig{
-			Termination: termination,
-			Certificate: string(keyPair.Cert),
-			Key:         string(keyPair.Key),
+		// a failed first issue leaves the TLS config of the route as it is
+		if err == nil && termination == v1.TLSTerminationPassthrough {
+			// the backend terminates TLS, so the certificate goes to a secret it mounts
+			if err := r.writeSecret(route, keyPair); err != nil {
+				reqLogger.Error(err, "Failed to apply secret")
+				return reconcile.Result{}, err
+			}
+		} else if err == nil {
+			// only the fields the operator owns are set, the rest of the TLS config is left to the user
+			tls := &v1.TLSConfig{}
+			if route.Spec.TLS != nil {
+				tls = route.Spec.TLS.DeepCopy()
+			}
+			tls.Termination = termination
+			tls.Certificate = string(keyPair.Cert)
+			tls.Key = string(keyPair.Key)
+			if len(keyPair.CA) > 0 {
+				tls.CACertificate = string(keyPair.CA)
+			}
+			if termination == v1.TLSTerminationReencrypt {
+				tls.DestinationCACertificate, err = r.destinationCA(route)
+				if err != nil {
+					return reconcile.Result{}, err
+				}
+			}
+			route.Spec.TLS = tls
 		}
 
-		err = helpers.Apply(r.client, route)
+		err = r.patch(original, route)
 		if err != nil {
 			return reconcile.Result{}, err
 		}
 
+		if route.ObjectMeta.Annotations[r.config.General.Annotations.Status] == "secured" {
+			r.recorder.Eventf(route, corev1.EventTypeNormal, helpers.IssuedReason(renewing), "Certificate issued, valid until %s", keyPair.Expiry.UTC().Format(helpers.TimeFormat))
+			helpers.NotifyIssued(r.ctx, r.notifications, "Route", route, certReq.Hosts(), renewing, keyPair.Expiry)
+		}
 		reqLogger.Info("Updated route with new certificate")
 	}
 
 	return reconcile.Result{}, nil
 }
 
+// destinationCA returns the CA a reencrypt route trusts for its backend. When the certificate of
+// the target service was issued by the operator that is the CA of the certificate, otherwise the
+// destination CA the route already has.
+func (r *ReconcileRoute) destinationCA(route *routev1.Route) (string, error) {
+	current := ""
+	if route.Spec.TLS != nil {
+		current = route.Spec.TLS.DestinationCACertificate
+	}
+
+	service := routeService(route)
+	if len(service) == 0 {
+		return current, nil
+	}
+
+	svc := &corev1.Service{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: route.Namespace, Name: service}, svc)
+	if errors.IsNotFound(err) {
+		return current, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if svc.ObjectMeta.Annotations[r.config.General.Annotations.Status] != "secured" {
+		return current, nil
+	}
+
+	secret := &corev1.Secret{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Namespace: route.Namespace, Name: helpers.ServiceSecretName(service)}, secret)
+	if errors.IsNotFound(err) {
+		return current, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	ca := helpers.SecretCA(secret)
+	if len(ca) == 0 {
+		return current, nil
+	}
+	return string(ca), nil
+}
+
+// patch sends the changes made to route since original to the server as a JSON merge patch,
+// so fields the operator did not touch keep whatever value they have by now
+func (r *ReconcileRoute) patch(original *routev1.Route, route *routev1.Route) error {
+	originalJSON, err := json.Marshal(original)
+	if err != nil {
+		return err
+	}
+	modifiedJSON, err := json.Marshal(route)
+	if err != nil {
+		return err
+	}
+	patch, err := jsonpatch.CreateMergePatch(originalJSON, modifiedJSON)
+	if err != nil {
+		return err
+	}
+	if string(patch) == "{}" {
+		return nil
+	}
+	// a merge patch replaces lists as a whole, so it would drop finalizers added by others since
+	// original was read. With its resourceVersion the server refuses the patch instead and the
+	// reconcile is retried.
+	if !reflect.DeepEqual(original.ObjectMeta.Finalizers, route.ObjectMeta.Finalizers) {
+		patch, err = withResourceVersion(patch, original.ObjectMeta.ResourceVersion)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = r.dynamic.Resource(routev1.SchemeGroupVersion.WithResource("routes")).Namespace(route.Namespace).
+		Patch(route.Name, types.MergePatchType, patch, metav1.UpdateOptions{})
+	return err
+}
+
+// withResourceVersion adds a precondition on resourceVersion to a merge patch
+func withResourceVersion(patch []byte, resourceVersion string) ([]byte, error) {
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(patch, &fields); err != nil {
+		return nil, err
+	}
+	metadata, ok := fields["metadata"].(map[string]interface{})
+	if !ok {
+		metadata = map[string]interface{}{}
+		fields["metadata"] = metadata
+	}
+	metadata["resourceVersion"] = resourceVersion
+	return json.Marshal(fields)
+}
+
+// revoke revokes the certificate the operator issued for a deleted route and releases the route
+func (r *ReconcileRoute) revoke(route *routev1.Route) error {
+	if !helpers.HasFinalizer(route, helpers.Finalizer) {
+		return nil
+	}
+	reqLogger := log.WithValues("Request.Namespace", route.Namespace, "Request.Name", route.Name)
+
+	var cert []byte
+	if isPassthrough(route) {
+		var err error
+		cert, err = r.passthroughCert(route)
+		if err != nil {
+			return err
+		}
+	} else if route.Spec.TLS != nil {
+		cert = []byte(route.Spec.TLS.Certificate)
+	}
+
+	// the route may hold a certificate set by hand since, only the one with a recorded expiry is ours
+	if len(cert) > 0 && len(route.ObjectMeta.Annotations[r.config.General.Annotations.Expiry]) > 0 {
+		if err := helpers.Revoke(r.ctx, r.issuers, route, cert); err != nil {
+			reqLogger.Error(err, "Failed to revoke certificate")
+			return err
+		}
+		reqLogger.Info("Revoked certificate of deleted route")
+	}
+
+	helpers.RemoveFinalizer(route, helpers.Finalizer)
+	return r.client.Update(context.TODO(), route)
+}
+
 // newPodForCR returns a busybox pod with the same name/namespace as the cr
 func newPodForCR(cr *routev1.Route) *corev1.Pod {
 	labels := map[string]string{
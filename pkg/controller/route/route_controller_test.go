@@ -0,0 +1,324 @@
+package route
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	routev1 "github.com/openshift/api/route/v1"
+	certsv1alpha1 "github.com/redhat-cop/cert-operator/pkg/apis/certs/v1alpha1"
+	"github.com/redhat-cop/cert-operator/pkg/certs"
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	"github.com/redhat-cop/cert-operator/pkg/helpers"
+	"github.com/redhat-cop/cert-operator/pkg/issuer"
+	"github.com/redhat-cop/cert-operator/pkg/notifier"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// failingProvider refuses every request
+type failingProvider struct{}
+
+func (p *failingProvider) Provision(ctx context.Context, req certs.CertificateRequest) (certs.KeyPair, error) {
+	return certs.KeyPair{}, certs.NewCertError("request denied")
+}
+
+func (p *failingProvider) Deprovision(ctx context.Context, cert []byte) error {
+	return nil
+}
+
+// patchedRoute is a route as patched by the reconciler, along with the patches
+type patchedRoute struct {
+	route   *routev1.Route
+	patches []map[string]interface{}
+}
+
+// newTestReconciler returns a reconciler issuing certificates with provider for route and the
+// other objs, and the route as it is patched
+func newTestReconciler(t *testing.T, provider certs.Provider, route *routev1.Route, objs ...runtime.Object) (*ReconcileRoute, *patchedRoute) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := routev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := certsv1alpha1.SchemeBuilder.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewFakeClientWithScheme(scheme, append(objs, route)...)
+	config := certconf.DefaultConfig()
+
+	notifications, err := notifier.NewDispatcher(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the object tracker of this client-go cannot apply merge patches
+	patched := &patchedRoute{route: route.DeepCopy()}
+	dynamic := dynamicfake.NewSimpleDynamicClient(scheme)
+	dynamic.PrependReactor("patch", "routes", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patch := action.(clienttesting.PatchAction).GetPatch()
+		fields := map[string]interface{}{}
+		if err := json.Unmarshal(patch, &fields); err != nil {
+			return true, nil, err
+		}
+		patched.patches = append(patched.patches, fields)
+
+		current, err := json.Marshal(patched.route)
+		if err != nil {
+			return true, nil, err
+		}
+		modified, err := jsonpatch.MergePatch(current, patch)
+		if err != nil {
+			return true, nil, err
+		}
+		patched.route = &routev1.Route{}
+		if err := json.Unmarshal(modified, patched.route); err != nil {
+			return true, nil, err
+		}
+		return true, patched.route.DeepCopy(), nil
+	})
+
+	return &ReconcileRoute{
+		client:        c,
+		scheme:        scheme,
+		config:        config,
+		issuers:       issuer.NewStaticResolver(c, config, issuer.Issuer{Provider: provider, Kind: "test"}),
+		ctx:           context.TODO(),
+		recorder:      record.NewFakeRecorder(10),
+		notifications: notifications,
+		dynamic:       dynamic,
+	}, patched
+}
+
+func newTestRoute(config certconf.Config) *routev1.Route {
+	return &routev1.Route{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "example",
+			Namespace:       "test",
+			ResourceVersion: "5",
+			Annotations: map[string]string{
+				config.General.Annotations.Status: config.General.Annotations.NeedCertValue,
+			},
+		},
+		Spec: routev1.RouteSpec{
+			Host: "www.example.com",
+			TLS: &routev1.TLSConfig{
+				Termination:   routev1.TLSTerminationEdge,
+				Certificate:   "certificate set by hand",
+				Key:           "key set by hand",
+				CACertificate: "CA set by hand",
+			},
+		},
+	}
+}
+
+var request = reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "test", Name: "example"}}
+
+func TestPatchAddingFinalizerIsConditional(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	original := newTestRoute(config)
+	original.ObjectMeta.Finalizers = []string{"example.com/other"}
+	r, patched := newTestReconciler(t, new(certs.SelfSignedProvider), original)
+
+	route := original.DeepCopy()
+	helpers.AddFinalizer(route, helpers.Finalizer)
+
+	// act
+	err := r.patch(original, route)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := patched.patches[0]["metadata"].(map[string]interface{})
+	if metadata["resourceVersion"] != "5" {
+		t.Fatalf("expected a patch of the finalizers to require resourceVersion 5, got %v", metadata)
+	}
+	if finalizers := metadata["finalizers"].([]interface{}); len(finalizers) != 2 {
+		t.Fatalf("expected both finalizers in the patch, got %v", finalizers)
+	}
+}
+
+func TestPatchAnnotationsIsUnconditional(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	original := newTestRoute(config)
+	r, patched := newTestReconciler(t, new(certs.SelfSignedProvider), original)
+
+	route := original.DeepCopy()
+	route.ObjectMeta.Annotations[config.General.Annotations.Status] = "secured"
+
+	// act
+	err := r.patch(original, route)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := patched.patches[0]["metadata"].(map[string]interface{})
+	if _, ok := metadata["resourceVersion"]; ok {
+		t.Fatal("patch of the annotations only should not depend on the resourceVersion")
+	}
+}
+
+func TestReconcileFailedIssueKeepsTLS(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	r, patched := newTestReconciler(t, new(failingProvider), newTestRoute(config))
+
+	// act
+	_, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patched.patches) != 1 {
+		t.Fatalf("expected one patch, got %d", len(patched.patches))
+	}
+	if spec, ok := patched.patches[0]["spec"]; ok {
+		t.Fatalf("failed issue changed the route spec: %v", spec)
+	}
+	annotations := patched.patches[0]["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})
+	if status := annotations[config.General.Annotations.Status]; status != "failed" {
+		t.Fatalf("expected status failed, got %v", status)
+	}
+	expectEvent(t, r, helpers.EventIssueFailed)
+}
+
+func TestReconcileIssues(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	r, patched := newTestReconciler(t, new(certs.SelfSignedProvider), newTestRoute(config))
+
+	// act
+	_, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	route := patched.route
+	leaf, err := certs.ParseCertificate([]byte(route.Spec.TLS.Certificate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.VerifyHostname("www.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if !helpers.HasFinalizer(route, helpers.Finalizer) {
+		t.Fatal("finalizer was not added")
+	}
+	expectEvent(t, r, helpers.EventIssued)
+}
+
+func TestReconcileRenews(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	route := newTestRoute(config)
+	route.ObjectMeta.Annotations[config.General.Annotations.Status] = "secured"
+	helpers.SetIssued(config, route, time.Now().Add(time.Minute))
+	route.ObjectMeta.Annotations[config.General.Annotations.Issued] = time.Now().Add(-time.Hour).UTC().Format(helpers.TimeFormat)
+	helpers.AddFinalizer(route, helpers.Finalizer)
+	r, patched := newTestReconciler(t, new(certs.SelfSignedProvider), route)
+
+	// act
+	_, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.route.Spec.TLS.Certificate == route.Spec.TLS.Certificate {
+		t.Fatal("certificate was not renewed")
+	}
+	expectEvent(t, r, helpers.EventRenewed)
+}
+
+func TestReconcileReencryptDestinationCA(t *testing.T) {
+	config := certconf.DefaultConfig()
+	secured := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "backend",
+			Namespace:   "test",
+			Annotations: map[string]string{config.General.Annotations.Status: "secured"},
+		},
+	}
+	unsecured := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "backend", Namespace: "test"}}
+	certificate := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: helpers.ServiceSecretName("backend"), Namespace: "test"},
+		Data:       map[string][]byte{"ca.crt": []byte("CA of the service")},
+	}
+	tests := []struct {
+		name     string
+		objs     []runtime.Object
+		expected string
+	}{
+		{
+			name:     "service with a certificate",
+			objs:     []runtime.Object{secured, certificate},
+			expected: "CA of the service",
+		},
+		{
+			name:     "no service",
+			expected: "destination CA set by hand",
+		},
+		{
+			name:     "service without a certificate",
+			objs:     []runtime.Object{unsecured},
+			expected: "destination CA set by hand",
+		},
+		{
+			name:     "secured service without its secret",
+			objs:     []runtime.Object{secured},
+			expected: "destination CA set by hand",
+		},
+	}
+	for _, test := range tests {
+		// setup
+		route := newTestRoute(config)
+		route.Spec.To = routev1.RouteTargetReference{Kind: "Service", Name: "backend"}
+		route.Spec.TLS.Termination = routev1.TLSTerminationReencrypt
+		route.Spec.TLS.DestinationCACertificate = "destination CA set by hand"
+		r, patched := newTestReconciler(t, new(certs.SelfSignedProvider), route, test.objs...)
+
+		// act
+		_, err := r.Reconcile(request)
+
+		// assert
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if tls := patched.route.Spec.TLS; tls.Termination != routev1.TLSTerminationReencrypt || len(tls.Certificate) == 0 {
+			t.Fatalf("%s: expected a reencrypt route with a certificate, got %v", test.name, tls)
+		}
+		if ca := patched.route.Spec.TLS.DestinationCACertificate; ca != test.expected {
+			t.Errorf("%s: expected destination CA %q, got %q", test.name, test.expected, ca)
+		}
+	}
+}
+
+// expectEvent fails t unless the next event recorded by r has reason
+func expectEvent(t *testing.T, r *ReconcileRoute, reason string) {
+	select {
+	case event := <-r.recorder.(*record.FakeRecorder).Events:
+		if !strings.Contains(event, " "+reason+" ") {
+			t.Fatalf("expected a %s event, got %s", reason, event)
+		}
+	default:
+		t.Fatalf("expected a %s event, got none", reason)
+	}
+}
@@ -0,0 +1,183 @@
+package route
+
+import (
+	"context"
+	goerrors "errors"
+	"reflect"
+	"sort"
+	"strings"
+
+	routev1 "github.com/openshift/api/route/v1"
+	certsv1alpha1 "github.com/redhat-cop/cert-operator/pkg/apis/certs/v1alpha1"
+	"github.com/redhat-cop/cert-operator/pkg/helpers"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ErrNotShared is returned when the Certificate named by the shared-certificate annotation of a
+// route exists but was not created for routes
+var ErrNotShared = goerrors.New("Certificate exists and is not shared by routes")
+
+// syncShared serves route from the Certificate shared by every route in its namespace whose
+// shared-certificate annotation holds name. The route controller keeps the hosts of that
+// Certificate in line with the routes, the certificate controller issues it once into the
+// Secret of the same name and the certificate is copied into the routes from there.
+func (r *ReconcileRoute) syncShared(route *routev1.Route, original *routev1.Route, name string) error {
+	reqLogger := log.WithValues("Request.Namespace", route.Namespace, "Request.Name", route.Name)
+
+	routes := &routev1.RouteList{}
+	if err := r.client.List(context.TODO(), &client.ListOptions{Namespace: route.Namespace}, routes); err != nil {
+		return err
+	}
+	// the cache may not have seen route yet
+	group := []routev1.Route{*route}
+	for _, item := range routes.Items {
+		if item.UID != route.UID && item.ObjectMeta.Annotations[r.config.General.Annotations.SharedCertificate] == name && item.GetDeletionTimestamp() == nil {
+			group = append(group, item)
+		}
+	}
+	sort.Slice(group, func(i, j int) bool { return group[i].Name < group[j].Name })
+
+	err := r.applySharedCertificate(route, name, group)
+	if err != nil {
+		if err != ErrNotShared {
+			return err
+		}
+		route.ObjectMeta.Annotations[r.config.General.Annotations.Status] = "failed"
+		route.ObjectMeta.Annotations[r.config.General.Annotations.StatusReason] = err.Error()
+		r.recorder.Event(route, corev1.EventTypeWarning, helpers.EventSyncFailed, err.Error())
+		return r.patch(original, route)
+	}
+
+	// until the certificate covering the route has been issued the route keeps what it has
+	secret := &corev1.Secret{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Namespace: route.Namespace, Name: name}, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	cert := helpers.SecretCert(secret, false)
+	if errors.IsNotFound(err) || cert == nil || cert.VerifyHostname(route.Spec.Host) != nil {
+		route.ObjectMeta.Annotations[r.config.General.Annotations.Status] = helpers.StatusPending
+		reqLogger.Info("Waiting for shared certificate to be issued", "Certificate", name)
+		// the secret watch brings the route back once it has been written
+		return r.patch(original, route)
+	}
+
+	return r.syncSecret(route, original, name)
+}
+
+// applySharedCertificate creates or updates the Certificate name so it covers the hosts of the
+// routes in group and is owned by them, so it is deleted along with the last of them
+func (r *ReconcileRoute) applySharedCertificate(route *routev1.Route, name string, group []routev1.Route) error {
+	hosts := r.sharedHosts(group)
+	var owners []metav1.OwnerReference
+	for _, item := range group {
+		owners = append(owners, metav1.OwnerReference{
+			APIVersion: routev1.SchemeGroupVersion.String(),
+			Kind:       "Route",
+			Name:       item.Name,
+			UID:        item.UID,
+		})
+	}
+
+	instance := &certsv1alpha1.Certificate{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: route.Namespace, Name: name}, instance)
+	if errors.IsNotFound(err) {
+		instance = &certsv1alpha1.Certificate{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: route.Namespace,
+				Annotations: map[string]string{
+					r.config.General.Annotations.SharedCertificate: name,
+				},
+				OwnerReferences: owners,
+			},
+			Spec: certsv1alpha1.CertificateSpec{
+				Hosts:      hosts,
+				SecretName: name,
+				IssuerRef:  r.issuerRef(route),
+			},
+		}
+		return r.client.Create(context.TODO(), instance)
+	}
+	if err != nil {
+		return err
+	}
+
+	// a Certificate someone created by hand is theirs, its secret can still be synced with source-secret
+	if instance.ObjectMeta.Annotations[r.config.General.Annotations.SharedCertificate] != name {
+		return ErrNotShared
+	}
+	// the issuer is that of the route the Certificate was created for
+	if issuer := r.issuerRef(route); !reflect.DeepEqual(instance.Spec.IssuerRef, issuer) {
+		r.recorder.Event(route, corev1.EventTypeWarning, helpers.EventIssuerIgnored,
+			"Certificate "+name+" is issued by "+issuerName(instance.Spec.IssuerRef)+", not "+issuerName(issuer))
+	}
+	if reflect.DeepEqual(instance.Spec.Hosts, hosts) && reflect.DeepEqual(instance.ObjectMeta.OwnerReferences, owners) {
+		return nil
+	}
+	instance.Spec.Hosts = hosts
+	instance.ObjectMeta.OwnerReferences = owners
+	return r.client.Update(context.TODO(), instance)
+}
+
+// sharedHosts returns the names the shared certificate of group is issued for, sorted. Routes
+// with the wildcard annotation set to true contribute a wildcard for the parent domain of their
+// host, which also covers the hosts of the other routes in that domain.
+func (r *ReconcileRoute) sharedHosts(group []routev1.Route) []string {
+	seen := map[string]bool{}
+	for _, item := range group {
+		host := strings.ToLower(item.Spec.Host)
+		if len(host) == 0 {
+			continue
+		}
+		if item.ObjectMeta.Annotations[r.config.General.Annotations.Wildcard] == "true" {
+			host = wildcardFor(host)
+		}
+		seen[host] = true
+	}
+
+	var hosts []string
+	for host := range seen {
+		if wildcard := wildcardFor(host); wildcard != host && seen[wildcard] {
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// wildcardFor returns the wildcard for the parent domain of host, or host itself when the
+// parent is a top level domain, which no CA issues wildcards for
+func wildcardFor(host string) string {
+	parent := host[strings.Index(host, ".")+1:]
+	if !strings.Contains(parent, ".") {
+		return host
+	}
+	return "*." + parent
+}
+
+// issuerName describes ref in events
+func issuerName(ref *certsv1alpha1.IssuerReference) string {
+	if ref == nil {
+		return "the default provider"
+	}
+	return ref.Kind + " " + ref.Name
+}
+
+// issuerRef returns a reference to the issuer named by the annotations of route, or nil for
+// the operator's provider
+func (r *ReconcileRoute) issuerRef(route *routev1.Route) *certsv1alpha1.IssuerReference {
+	if name := route.ObjectMeta.Annotations[r.config.General.Annotations.Issuer]; len(name) > 0 {
+		return &certsv1alpha1.IssuerReference{Name: name, Kind: certsv1alpha1.IssuerKind}
+	}
+	if name := route.ObjectMeta.Annotations[r.config.General.Annotations.ClusterIssuer]; len(name) > 0 {
+		return &certsv1alpha1.IssuerReference{Name: name, Kind: certsv1alpha1.ClusterIssuerKind}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package route
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	routev1 "github.com/openshift/api/route/v1"
+	certsv1alpha1 "github.com/redhat-cop/cert-operator/pkg/apis/certs/v1alpha1"
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+func newSharedRoute(config certconf.Config, name string, host string, wildcard bool) routev1.Route {
+	route := routev1.Route{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "test",
+			Annotations: map[string]string{
+				config.General.Annotations.SharedCertificate: "apps-team",
+			},
+		},
+		Spec: routev1.RouteSpec{Host: host},
+	}
+	if wildcard {
+		route.ObjectMeta.Annotations[config.General.Annotations.Wildcard] = "true"
+	}
+	return route
+}
+
+func TestWildcardFor(t *testing.T) {
+	tests := map[string]string{
+		"blog.apps.team.example.com": "*.apps.team.example.com",
+		"www.example.com":            "*.example.com",
+		"Example.com":                "Example.com",
+		"localhost":                  "localhost",
+	}
+	for host, expected := range tests {
+		if wildcard := wildcardFor(host); wildcard != expected {
+			t.Errorf("expected %s for %s, got %s", expected, host, wildcard)
+		}
+	}
+}
+
+func TestSharedHosts(t *testing.T) {
+	config := certconf.DefaultConfig()
+	r := &ReconcileRoute{config: config}
+	tests := []struct {
+		name     string
+		group    []routev1.Route
+		expected []string
+	}{
+		{
+			name: "hosts are sorted and lower case",
+			group: []routev1.Route{
+				newSharedRoute(config, "shop", "Shop.apps.team.example.com", false),
+				newSharedRoute(config, "blog", "blog.apps.team.example.com", false),
+				newSharedRoute(config, "copy", "blog.apps.team.example.com", false),
+			},
+			expected: []string{"blog.apps.team.example.com", "shop.apps.team.example.com"},
+		},
+		{
+			name: "wildcard covers the hosts of its domain",
+			group: []routev1.Route{
+				newSharedRoute(config, "shop", "shop.apps.team.example.com", false),
+				newSharedRoute(config, "blog", "blog.apps.team.example.com", true),
+				newSharedRoute(config, "api", "api.team.example.com", false),
+			},
+			expected: []string{"*.apps.team.example.com", "api.team.example.com"},
+		},
+		{
+			name: "routes without a host are skipped",
+			group: []routev1.Route{
+				newSharedRoute(config, "shop", "", false),
+				newSharedRoute(config, "blog", "blog.apps.team.example.com", false),
+			},
+			expected: []string{"blog.apps.team.example.com"},
+		},
+	}
+	for _, test := range tests {
+		if hosts := r.sharedHosts(test.group); !reflect.DeepEqual(hosts, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, hosts)
+		}
+	}
+}
+
+func TestApplySharedCertificateNotShared(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	route := newSharedRoute(config, "shop", "shop.apps.team.example.com", false)
+	byHand := &certsv1alpha1.Certificate{
+		ObjectMeta: metav1.ObjectMeta{Name: "apps-team", Namespace: "test"},
+		Spec:       certsv1alpha1.CertificateSpec{Hosts: []string{"www.example.com"}, SecretName: "apps-team"},
+	}
+	r, _ := newTestReconciler(t, new(revokingProvider), &route, byHand)
+
+	// act
+	err := r.applySharedCertificate(&route, "apps-team", []routev1.Route{route})
+
+	// assert
+	if err != ErrNotShared {
+		t.Fatalf("expected ErrNotShared, got %v", err)
+	}
+	instance := &certsv1alpha1.Certificate{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: "test", Name: "apps-team"}, instance); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(instance.Spec.Hosts, []string{"www.example.com"}) {
+		t.Fatal("Certificate created by hand was taken over")
+	}
+}
+
+func TestApplySharedCertificateWarnsOfIgnoredIssuer(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	shop := newSharedRoute(config, "shop", "shop.apps.team.example.com", false)
+	shop.ObjectMeta.Annotations[config.General.Annotations.Issuer] = "venafi"
+	blog := newSharedRoute(config, "blog", "blog.apps.team.example.com", false)
+	blog.ObjectMeta.Annotations[config.General.Annotations.Issuer] = "letsencrypt"
+	r, _ := newTestReconciler(t, new(revokingProvider), &shop, &blog)
+	recorder := r.recorder.(*record.FakeRecorder)
+	if err := r.applySharedCertificate(&shop, "apps-team", []routev1.Route{shop}); err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	err := r.applySharedCertificate(&blog, "apps-team", []routev1.Route{blog, shop})
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := &certsv1alpha1.Certificate{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: "test", Name: "apps-team"}, instance); err != nil {
+		t.Fatal(err)
+	}
+	if instance.Spec.IssuerRef == nil || instance.Spec.IssuerRef.Name != "venafi" {
+		t.Fatalf("expected the issuer of the first route, got %v", instance.Spec.IssuerRef)
+	}
+	if len(recorder.Events) != 1 {
+		t.Fatalf("expected one event, got %d", len(recorder.Events))
+	}
+	if event := <-recorder.Events; !strings.Contains(event, "IssuerIgnored") || !strings.Contains(event, "Issuer venafi") {
+		t.Fatalf("unexpected event %s", event)
+	}
+}
@@ -0,0 +1,151 @@
+package route
+
+import (
+	"context"
+	"time"
+
+	routev1 "github.com/openshift/api/route/v1"
+	"github.com/redhat-cop/cert-operator/pkg/certs"
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	"github.com/redhat-cop/cert-operator/pkg/helpers"
+	"github.com/redhat-cop/cert-operator/pkg/metrics"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// expiryWarningInterval is how often a route whose source certificate is about to expire is
+// checked again. The notifiers hold back repeated warnings for an hour anyway.
+const expiryWarningInterval = time.Hour
+
+// routesForSecret returns requests for the routes in namespace whose source-secret or
+// shared-certificate annotation names the secret
+func routesForSecret(c client.Client, annotations certconf.AnnotationConfig, namespace string, secret string) []reconcile.Request {
+	routes := &routev1.RouteList{}
+	if err := c.List(context.TODO(), &client.ListOptions{Namespace: namespace}, routes); err != nil {
+		log.Error(err, "Failed to list routes of secret", "Namespace", namespace, "Secret", secret)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, route := range routes.Items {
+		if route.ObjectMeta.Annotations[annotations.SourceSecret] == secret || route.ObjectMeta.Annotations[annotations.SharedCertificate] == secret {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: route.Namespace, Name: route.Name}})
+		}
+	}
+	return requests
+}
+
+// syncSource serves route from the Secret named by the source-secret annotation. The certificate
+// belongs to the user and is not renewed by the operator, so as it nears its expiry the user is
+// warned through an Event and the notifiers until it is replaced.
+func (r *ReconcileRoute) syncSource(route *routev1.Route, original *routev1.Route, name string) (reconcile.Result, error) {
+	if err := r.syncSecret(route, original, name); err != nil {
+		return reconcile.Result{}, err
+	}
+	expiry, err := helpers.Expiry(r.config, route)
+	if err != nil || route.ObjectMeta.Annotations[r.config.General.Annotations.Status] != "secured" {
+		// failures are reported by syncSecret
+		return reconcile.Result{}, nil
+	}
+
+	if wait := time.Until(expiry.Add(-r.config.General.ExpiryWarningDuration())); wait > 0 {
+		return reconcile.Result{RequeueAfter: wait}, nil
+	}
+	err = certs.NewCertError("certificate of secret " + name + " is supplied by the user and is not renewed by the operator")
+	r.recorder.Eventf(route, corev1.EventTypeWarning, helpers.EventExpiring, "Certificate of secret %s expires at %s and has to be replaced", name, expiry.UTC().Format(helpers.TimeFormat))
+	helpers.NotifyFailed(r.ctx, r.notifications, r.config, "Route", route, []string{route.Spec.Host}, expiry, err)
+	return reconcile.Result{RequeueAfter: expiryWarningInterval}, nil
+}
+
+// syncSecret copies the certificate of the kubernetes.io/tls Secret named by route into it.
+// Nothing is issued, but a certificate the operator issued for route earlier is revoked.
+func (r *ReconcileRoute) syncSecret(route *routev1.Route, original *routev1.Route, name string) error {
+	reqLogger := log.WithValues("Request.Namespace", route.Namespace, "Request.Name", route.Name)
+
+	var keyPair certs.KeyPair
+	secret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: route.Namespace, Name: name}, secret)
+	if errors.IsNotFound(err) {
+		err = certs.NewCertError("secret " + name + " does not exist")
+	} else if err != nil {
+		return err
+	} else {
+		keyPair, err = helpers.SourceKeyPair(secret)
+	}
+	if err == nil && route.Spec.TLS != nil && route.Spec.TLS.Termination == routev1.TLSTerminationPassthrough {
+		err = certs.NewCertError("Certificate and key cannot be set on Passthrough route")
+	}
+	if err == nil {
+		leaf, leafErr := keyPair.Leaf()
+		if leafErr != nil {
+			err = leafErr
+		} else if leaf.VerifyHostname(route.Spec.Host) != nil {
+			err = certs.NewErrBadHost("certificate of secret " + name + " does not cover " + route.Spec.Host)
+		}
+	}
+
+	if err != nil {
+		route.ObjectMeta.Annotations[r.config.General.Annotations.Status] = "failed"
+		route.ObjectMeta.Annotations[r.config.General.Annotations.StatusReason] = err.Error()
+		r.recorder.Event(route, corev1.EventTypeWarning, helpers.EventSyncFailed, err.Error())
+		expiry, _ := helpers.Expiry(r.config, route)
+		helpers.NotifyFailed(r.ctx, r.notifications, r.config, "Route", route, []string{route.Spec.Host}, expiry, err)
+		// the secret watch brings the route back once the secret is fixed
+		return r.patch(original, route)
+	}
+
+	// a certificate the operator issued for the route is revoked once it is replaced
+	if previous := r.issuedCert(original); len(previous) > 0 && previous != string(keyPair.Cert) {
+		if err := helpers.Revoke(r.ctx, r.issuers, route, []byte(previous)); err != nil {
+			reqLogger.Error(err, "Failed to revoke replaced certificate")
+			return err
+		}
+		reqLogger.Info("Revoked certificate replaced by the certificate of secret", "Secret", name)
+	}
+
+	// only the fields the operator owns are set, the rest of the TLS config is left to the user
+	tls := &routev1.TLSConfig{Termination: routev1.TLSTerminationEdge}
+	if route.Spec.TLS != nil {
+		tls = route.Spec.TLS.DeepCopy()
+	}
+	changed := tls.Certificate != string(keyPair.Cert) || tls.Key != string(keyPair.Key)
+	tls.Certificate = string(keyPair.Cert)
+	tls.Key = string(keyPair.Key)
+	if len(keyPair.CA) > 0 {
+		tls.CACertificate = string(keyPair.CA)
+	}
+	route.Spec.TLS = tls
+
+	route.ObjectMeta.Annotations[r.config.General.Annotations.Status] = "secured"
+	delete(route.ObjectMeta.Annotations, r.config.General.Annotations.StatusReason)
+	if changed {
+		helpers.SetIssued(r.config, route, keyPair.Expiry)
+	}
+	// the certificate the operator issued earlier, if any, has been revoked
+	helpers.RemoveFinalizer(route, helpers.Finalizer)
+	metrics.SetExpiry("Route", route.Namespace, route.Name, keyPair.Expiry)
+
+	if err := r.patch(original, route); err != nil {
+		return err
+	}
+	if changed {
+		r.recorder.Eventf(route, corev1.EventTypeNormal, helpers.EventSynced, "Certificate synced from secret %s, valid until %s", name, keyPair.Expiry.UTC().Format(helpers.TimeFormat))
+		reqLogger.Info("Updated route with certificate of secret", "Secret", name)
+	}
+	return nil
+}
+
+// issuedCert returns the PEM certificate the operator issued into the TLS config of route, or
+// an empty string when route does not hold one. The finalizer marks a route whose certificate
+// was issued by the operator.
+func (r *ReconcileRoute) issuedCert(route *routev1.Route) string {
+	if !helpers.HasFinalizer(route, helpers.Finalizer) || route.Spec.TLS == nil || isPassthrough(route) ||
+		len(route.ObjectMeta.Annotations[r.config.General.Annotations.Expiry]) == 0 {
+		return ""
+	}
+	return route.Spec.TLS.Certificate
+}
@@ -0,0 +1,153 @@
+package route
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	routev1 "github.com/openshift/api/route/v1"
+	"github.com/redhat-cop/cert-operator/pkg/certs"
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	"github.com/redhat-cop/cert-operator/pkg/helpers"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// revokingProvider issues self-signed certificates and remembers the ones it revoked
+type revokingProvider struct {
+	certs.SelfSignedProvider
+	revoked []string
+}
+
+func (p *revokingProvider) Deprovision(ctx context.Context, cert []byte) error {
+	p.revoked = append(p.revoked, string(cert))
+	return nil
+}
+
+// newSourceSecret returns a kubernetes.io/tls Secret holding a self-signed certificate for
+// host, valid for duration
+func newSourceSecret(t *testing.T, host string, duration time.Duration) (*corev1.Secret, certs.KeyPair) {
+	req := certs.NewCertificateRequest(host)
+	req.Duration = duration
+	keyPair, err := new(certs.SelfSignedProvider).Provision(context.TODO(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "user-tls", Namespace: "test"},
+		Data: map[string][]byte{
+			"tls.crt": keyPair.Cert,
+			"tls.key": keyPair.Key,
+		},
+		Type: corev1.SecretTypeTLS,
+	}, keyPair
+}
+
+func newSourceRoute(config certconf.Config) *routev1.Route {
+	route := newTestRoute(config)
+	route.ObjectMeta.Annotations = map[string]string{config.General.Annotations.SourceSecret: "user-tls"}
+	return route
+}
+
+func TestSyncSecret(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	secret, keyPair := newSourceSecret(t, "www.example.com", 365*24*time.Hour)
+	route := newSourceRoute(config)
+	r, patched := newTestReconciler(t, new(revokingProvider), route, secret)
+
+	// act
+	result, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched.route.Spec.TLS.Certificate != string(keyPair.Cert) || patched.route.Spec.TLS.Key != string(keyPair.Key) {
+		t.Fatal("certificate of the secret was not copied into the route")
+	}
+	if status := patched.route.ObjectMeta.Annotations[config.General.Annotations.Status]; status != "secured" {
+		t.Fatalf("expected status secured, got %s", status)
+	}
+	// checked again when it is time to warn of the expiry
+	if result.RequeueAfter < 300*24*time.Hour {
+		t.Fatalf("expected a requeue at the expiry warning, got %s", result.RequeueAfter)
+	}
+}
+
+func TestSyncSecretRevokesIssuedCertificate(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	secret, keyPair := newSourceSecret(t, "www.example.com", 365*24*time.Hour)
+	route := newSourceRoute(config)
+	route.Spec.TLS.Certificate = "certificate issued by the operator"
+	helpers.SetIssued(config, route, time.Now().Add(time.Hour))
+	helpers.AddFinalizer(route, helpers.Finalizer)
+	provider := new(revokingProvider)
+	r, patched := newTestReconciler(t, provider, route, secret)
+
+	// act
+	_, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(provider.revoked) != 1 || provider.revoked[0] != "certificate issued by the operator" {
+		t.Fatalf("expected the replaced certificate to be revoked, got %v", provider.revoked)
+	}
+	if helpers.HasFinalizer(patched.route, helpers.Finalizer) {
+		t.Fatal("finalizer was not removed")
+	}
+	if patched.route.Spec.TLS.Certificate != string(keyPair.Cert) {
+		t.Fatal("certificate of the secret was not copied into the route")
+	}
+}
+
+func TestSyncSecretWrongHost(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	secret, _ := newSourceSecret(t, "other.example.com", 365*24*time.Hour)
+	r, patched := newTestReconciler(t, new(revokingProvider), newSourceRoute(config), secret)
+
+	// act
+	_, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := patched.route.ObjectMeta.Annotations[config.General.Annotations.Status]; status != "failed" {
+		t.Fatalf("expected status failed, got %s", status)
+	}
+	if patched.route.Spec.TLS.Certificate != "certificate set by hand" {
+		t.Fatal("certificate of the route was replaced")
+	}
+}
+
+func TestSyncSecretWarnsOfExpiry(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	secret, _ := newSourceSecret(t, "www.example.com", 24*time.Hour)
+	r, _ := newTestReconciler(t, new(revokingProvider), newSourceRoute(config), secret)
+
+	// act
+	result, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RequeueAfter != expiryWarningInterval {
+		t.Fatalf("expected the warning to be repeated after %s, got %s", expiryWarningInterval, result.RequeueAfter)
+	}
+	events := r.recorder.(*record.FakeRecorder).Events
+	for len(events) > 0 {
+		if event := <-events; strings.Contains(event, helpers.EventExpiring) {
+			return
+		}
+	}
+	t.Fatal("no expiry warning was recorded")
+}
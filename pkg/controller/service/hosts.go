@@ -0,0 +1,65 @@
+package service
+
+import (
+	"strings"
+
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// serviceHosts returns the names and addresses the certificate of svc is issued for: its
+// cluster DNS name with and without the cluster domain, the names listed in the extra-sans
+// annotation and, when they are opted into, its short names, its cluster IP and the addresses
+// of its load balancer. <name>.<namespace>.svc comes first and so becomes the common name.
+func serviceHosts(config certconf.Config, svc *corev1.Service) []string {
+	name := svc.ObjectMeta.Name
+	namespace := svc.ObjectMeta.Namespace
+	optional := serviceSANs(config, svc)
+
+	hosts := []string{name + "." + namespace + ".svc"}
+	if len(config.General.ClusterDomain) > 0 {
+		hosts = append(hosts, name+"."+namespace+".svc."+strings.Trim(config.General.ClusterDomain, "."))
+	}
+	if optional[certconf.ServiceSANShortNames] {
+		hosts = append(hosts, name+"."+namespace, name)
+	}
+
+	if optional[certconf.ServiceSANClusterIP] && len(svc.Spec.ClusterIP) > 0 && svc.Spec.ClusterIP != corev1.ClusterIPNone {
+		hosts = append(hosts, svc.Spec.ClusterIP)
+	}
+	if optional[certconf.ServiceSANLoadBalancer] {
+		for _, ingress := range svc.Status.LoadBalancer.Ingress {
+			hosts = append(hosts, ingress.IP, ingress.Hostname)
+		}
+	}
+	for _, san := range strings.Split(svc.ObjectMeta.Annotations[config.General.Annotations.ExtraSANs], ",") {
+		hosts = append(hosts, san)
+	}
+
+	// without duplicates or empty entries, which would turn into empty SANs
+	var unique []string
+	seen := map[string]bool{}
+	for _, host := range hosts {
+		host = strings.TrimSpace(host)
+		if len(host) > 0 && !seen[host] {
+			seen[host] = true
+			unique = append(unique, host)
+		}
+	}
+	return unique
+}
+
+// serviceSANs returns the optional names and addresses svc opted into, with its service-sans
+// annotation or else general.service-sans. Short names and addresses that are not in DNS are
+// left out by default since most CAs refuse to issue for them.
+func serviceSANs(config certconf.Config, svc *corev1.Service) map[string]bool {
+	forms := config.General.ServiceSANs
+	if value, ok := svc.ObjectMeta.Annotations[config.General.Annotations.ServiceSANs]; ok {
+		forms = strings.Split(value, ",")
+	}
+	optional := map[string]bool{}
+	for _, form := range forms {
+		optional[strings.TrimSpace(form)] = true
+	}
+	return optional
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newLoadBalancer(annotations map[string]string) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "dotnet", Namespace: "test", Annotations: annotations},
+		Spec:       corev1.ServiceSpec{ClusterIP: "172.30.0.10", Type: corev1.ServiceTypeLoadBalancer},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{
+				Ingress: []corev1.LoadBalancerIngress{{IP: "203.0.113.10"}, {Hostname: "lb.example.com"}},
+			},
+		},
+	}
+}
+
+func TestServiceHosts(t *testing.T) {
+	config := certconf.DefaultConfig()
+	withOptional := certconf.DefaultConfig()
+	withOptional.General.ServiceSANs = []string{"short-names", "cluster-ip", "load-balancer"}
+	tests := []struct {
+		name     string
+		config   certconf.Config
+		svc      *corev1.Service
+		expected []string
+	}{
+		{
+			name:     "cluster DNS names by default",
+			config:   config,
+			svc:      newLoadBalancer(nil),
+			expected: []string{"dotnet.test.svc", "dotnet.test.svc.cluster.local"},
+		},
+		{
+			name:   "opted into by config",
+			config: withOptional,
+			svc:    newLoadBalancer(nil),
+			expected: []string{"dotnet.test.svc", "dotnet.test.svc.cluster.local", "dotnet.test", "dotnet",
+				"172.30.0.10", "203.0.113.10", "lb.example.com"},
+		},
+		{
+			name:   "opted into by annotation",
+			config: config,
+			svc: newLoadBalancer(map[string]string{
+				config.General.Annotations.ServiceSANs: "cluster-ip, load-balancer",
+				config.General.Annotations.ExtraSANs:   "dotnet.example.com,,203.0.113.10",
+			}),
+			expected: []string{"dotnet.test.svc", "dotnet.test.svc.cluster.local",
+				"172.30.0.10", "203.0.113.10", "lb.example.com", "dotnet.example.com"},
+		},
+		{
+			name:   "annotation takes the place of the config",
+			config: withOptional,
+			svc: newLoadBalancer(map[string]string{
+				config.General.Annotations.ServiceSANs: "",
+			}),
+			expected: []string{"dotnet.test.svc", "dotnet.test.svc.cluster.local"},
+		},
+	}
+	for _, test := range tests {
+		if hosts := serviceHosts(test.config, test.svc); !reflect.DeepEqual(hosts, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, hosts)
+		}
+	}
+}
@@ -2,18 +2,23 @@ package service
 
 import (
 	"context"
-	"encoding/pem"
+	"time"
 
 	"github.com/redhat-cop/cert-operator/pkg/certs"
 	certconf "github.com/redhat-cop/cert-operator/pkg/config"
 	"github.com/redhat-cop/cert-operator/pkg/helpers"
-	"github.com/redhat-cop/cert-operator/pkg/rand"
+	"github.com/redhat-cop/cert-operator/pkg/issuer"
+	"github.com/redhat-cop/cert-operator/pkg/metrics"
+	"github.com/redhat-cop/cert-operator/pkg/notifier"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -25,41 +30,21 @@ var log = logf.Log.WithName("controller_service")
 
 // Add creates a new Service Controller and adds it to the Manager. The Manager will set fields on the Controller
 // and Start it when the Manager is Started.
-func Add(mgr manager.Manager, config certconf.Config) error {
-	return add(mgr, newReconciler(mgr, config))
+func Add(mgr manager.Manager, config certconf.Config, issuers *issuer.Resolver, notifications *notifier.Dispatcher) error {
+	return add(mgr, newReconciler(mgr, config, issuers, notifications))
 }
 
 // newReconciler returns a new reconcile.Reconciler
-func newReconciler(mgr manager.Manager, config certconf.Config) reconcile.Reconciler {
-	var provider certs.Provider
-
-	if config.Provider.Ssl == "true" {
-		// logrus.Infof("SSL Verified")
-		log.Info("SSL Verified")
-	} else {
-		// logrus.Infof("SSL Not Verified")
-		log.Info("SSL Not Verified")
-	}
-
-	switch config.Provider.Kind {
-	case "none":
-		// logrus.Infof("None provider.")
-		log.Info("None provider.")
-		provider = new(certs.NoneProvider)
-	case "self-signed":
-		// logrus.Infof("Self Signed provider.")
-		log.Info("Self Signed provider.")
-		provider = new(certs.SelfSignedProvider)
-	case "venafi":
-		// logrus.Infof("Venafi Cert provider.")
-		provider = new(certs.VenafiProvider)
-	default:
-		panic("There was a problem detecting which provider to configure. \n" +
-			"\tProvider kind `" + config.Provider.Kind + "` is invalid. \n" +
-			config.String())
-	}
-
-	return &ReconcileService{client: mgr.GetClient(), scheme: mgr.GetScheme(), config: config, provider: provider}
+func newReconciler(mgr manager.Manager, config certconf.Config, issuers *issuer.Resolver,
+	notifications *notifier.Dispatcher) reconcile.Reconciler {
+	ctx, err := helpers.ManagerContext(mgr)
+	if err != nil {
+		panic("There was a problem registering the shutdown handler. \n" +
+			"\t" + err.Error())
+	}
+
+	return &ReconcileService{client: mgr.GetClient(), scheme: mgr.GetScheme(), config: config, issuers: issuers, ctx: ctx,
+		recorder: mgr.GetRecorder("service-controller"), notifications: notifications}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
@@ -94,10 +79,14 @@ var _ reconcile.Reconciler = &ReconcileService{}
 type ReconcileService struct {
 	// This client, initialized using mgr.Client() above, is a split client
 	// that reads objects from the cache and writes to the apiserver
-	client   client.Client
-	scheme   *runtime.Scheme
-	config   certconf.Config
-	provider certs.Provider
+	client  client.Client
+	scheme  *runtime.Scheme
+	config  certconf.Config
+	issuers *issuer.Resolver
+	// ctx is cancelled when the manager stops, abandoning in-flight provider calls
+	ctx           context.Context
+	recorder      record.EventRecorder
+	notifications *notifier.Dispatcher
 }
 
 // Reconcile reads that state of the cluster for a Service object and makes changes based on the state read
@@ -116,75 +105,112 @@ func (r *ReconcileService) Reconcile(request reconcile.Request) (reconcile.Resul
 			// Request object not found, could have been deleted after reconcile request.
 			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
 			// Return and don't requeue
+			metrics.DeleteExpiry("Service", request.Namespace, request.Name)
 			return reconcile.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
 		return reconcile.Result{}, err
 	}
 
+	if svc.GetDeletionTimestamp() != nil {
+		return reconcile.Result{}, r.revoke(svc)
+	}
+
 	// Look for annoation that requires action, otherwise skip it
 	if svc.ObjectMeta.Annotations == nil || svc.ObjectMeta.Annotations[r.config.General.Annotations.Status] == "" {
+		// the annotation may have been removed from an object that had a certificate
+		metrics.DeleteExpiry("Service", request.Namespace, request.Name)
 		return reconcile.Result{}, nil
 	}
 
-	if svc.ObjectMeta.Annotations[r.config.General.Annotations.Status] == r.config.General.Annotations.NeedCertValue {
-		reqLogger.Info("Reconciling Service")
-
-		host := svc.ObjectMeta.Name + "." + svc.ObjectMeta.Namespace + ".svc"
-
-		keyPair, err := helpers.GetCert(host, r.provider, r.config.Provider.Ssl)
+	status := svc.ObjectMeta.Annotations[r.config.General.Annotations.Status]
+	if expiry, err := helpers.Expiry(r.config, svc); err == nil {
+		metrics.SetExpiry("Service", svc.Namespace, svc.Name, expiry)
+	}
+	if status == "secured" {
+		intact, err := r.secretIntact(svc)
 		if err != nil {
-			svc.ObjectMeta.Annotations[r.config.General.Annotations.Status] = "failed"
-			svc.ObjectMeta.Annotations[r.config.General.Annotations.StatusReason] = err.Error()
-		} else {
-			svc.ObjectMeta.Annotations[r.config.General.Annotations.Status] = "secured"
-			svc.ObjectMeta.Annotations[r.config.General.Annotations.Expiry] = keyPair.Expiry.Format(helpers.TimeFormat)
+			return reconcile.Result{}, err
 		}
 
-		dm := make(map[string][]byte)
-		secretType := corev1.SecretTypeTLS
+		renewAt, err := helpers.NextRenewal(r.config, svc)
+		if !intact {
+			reqLogger.Info("Certificate secret is missing or was modified, issuing a new certificate")
+		} else if helpers.HostsChanged(r.config, svc, serviceHosts(r.config, svc)) {
+			reqLogger.Info("Service names changed, issuing a new certificate")
+		} else if err != nil {
+			reqLogger.Error(err, "Cannot read certificate expiry, renewing now")
+		} else if wait := time.Until(renewAt); wait > 0 {
+			return reconcile.Result{RequeueAfter: wait}, nil
+		} else {
+			reqLogger.Info("Renewing certificate")
+		}
+	}
 
-		// see what format was requested
-		if svc.ObjectMeta.Annotations[r.config.General.Annotations.Format] == r.config.General.Annotations.Pkcs12Format {
-			password := rand.String(24)
-			pemCrt, _ := pem.Decode(keyPair.Cert)
-			pemKey, _ := pem.Decode(keyPair.Key)
-			p12cert, err := certs.ConvertToPKCS12(pemKey.Bytes, pemCrt.Bytes, [][]byte{}, password)
+	// a certificate that is being renewed stays in place until its successor is issued
+	renewing := status != r.config.General.Annotations.NeedCertValue && len(svc.ObjectMeta.Annotations[r.config.General.Annotations.Expiry]) > 0
 
-			if err != nil {
-				reqLogger.Error(err, "Failed to convert to PKCS12")
-				return reconcile.Result{}, err
-			}
+	if status == r.config.General.Annotations.NeedCertValue || status == helpers.StatusPending || status == "secured" {
+		reqLogger.Info("Reconciling Service")
 
-			dm["tls.p12"] = p12cert
-			dm["tls-p12-secret.txt"] = []byte(password)
+		certReq := certs.NewCertificateRequest(serviceHosts(r.config, svc)...)
+		certReq.Options[certs.OptionNamespace] = svc.Namespace
 
-			// override secret type since it's not tls
-			secretType = corev1.SecretTypeOpaque
+		var keyPair certs.KeyPair
+		issued := false
+		failure := helpers.EventIssueFailed
+		iss, err := r.issuers.ForObject(r.ctx, svc)
+		if err != nil {
+			failure = helpers.EventProviderUnavailable
 		} else {
-			dm["tls.crt"] = keyPair.Cert
-			dm["tls.key"] = keyPair.Key
+			certReq.Duration = iss.CertDuration(r.config.General.CertDuration())
+			keyPair, issued, err = helpers.ObtainCert(r.ctx, r.client, r.scheme, r.config, svc, certReq, iss.Provider, svc.Name+"-service-pending-key")
+			metrics.ObserveIssue(iss.Kind, svc.Namespace, issued, err)
 		}
-
-		// Create a secret
-		certSec := &corev1.Secret{
-			TypeMeta: metav1.TypeMeta{
-				Kind:       "Secret",
-				APIVersion: "v1",
-			},
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      svc.ObjectMeta.Name + "-certificate",
-				Namespace: svc.ObjectMeta.Namespace,
-			},
-			Data: dm,
-			Type: secretType,
+		if r.ctx.Err() != nil {
+			// shutting down, leave the status alone so the next leader picks it up
+			return reconcile.Result{}, r.ctx.Err()
+		}
+		if err == nil && !issued {
+			// the CA has not issued the certificate yet, collect it on a later reconcile
+			svc.ObjectMeta.Annotations[r.config.General.Annotations.Status] = helpers.StatusPending
+			err = helpers.Apply(r.client, svc)
+			if err != nil {
+				return reconcile.Result{}, err
+			}
+			reqLogger.Info("Waiting for certificate to be issued")
+			return reconcile.Result{RequeueAfter: r.config.General.PollDuration()}, nil
 		}
-
-		err = helpers.Apply(r.client, certSec)
 		if err != nil {
-			reqLogger.Error(err, "Failed to apply secret")
+			r.recorder.Event(svc, corev1.EventTypeWarning, failure, err.Error())
+			expiry, _ := helpers.Expiry(r.config, svc)
+			helpers.NotifyFailed(r.ctx, r.notifications, r.config, "Service", svc, certReq.Hosts(), expiry, err)
+		}
+		if err != nil && renewing {
+			// keep serving the current certificate and retry with backoff
+			svc.ObjectMeta.Annotations[r.config.General.Annotations.Status] = "secured"
+			svc.ObjectMeta.Annotations[r.config.General.Annotations.StatusReason] = "Renewal failed: " + err.Error()
+			if applyErr := helpers.Apply(r.client, svc); applyErr != nil {
+				return reconcile.Result{}, applyErr
+			}
 			return reconcile.Result{}, err
 		}
+		if err != nil {
+			svc.ObjectMeta.Annotations[r.config.General.Annotations.Status] = "failed"
+			svc.ObjectMeta.Annotations[r.config.General.Annotations.StatusReason] = err.Error()
+		} else {
+			// the secret is only written once there is a certificate to put in it
+			err = r.writeSecret(svc, keyPair)
+			if err != nil {
+				reqLogger.Error(err, "Failed to apply secret")
+				return reconcile.Result{}, err
+			}
+			svc.ObjectMeta.Annotations[r.config.General.Annotations.Status] = "secured"
+			delete(svc.ObjectMeta.Annotations, r.config.General.Annotations.StatusReason)
+			helpers.SetIssued(r.config, svc, keyPair.Expiry)
+			helpers.SetHosts(r.config, svc, certReq.Hosts())
+			helpers.AddFinalizer(svc, helpers.Finalizer)
+		}
 
 		err = helpers.Apply(r.client, svc)
 		if err != nil {
@@ -192,8 +218,86 @@ func (r *ReconcileService) Reconcile(request reconcile.Request) (reconcile.Resul
 			return reconcile.Result{}, err
 		}
 
+		if svc.ObjectMeta.Annotations[r.config.General.Annotations.Status] == "secured" {
+			r.recorder.Eventf(svc, corev1.EventTypeNormal, helpers.IssuedReason(renewing), "Certificate issued, valid until %s", keyPair.Expiry.UTC().Format(helpers.TimeFormat))
+			helpers.NotifyIssued(r.ctx, r.notifications, "Service", svc, certReq.Hosts(), renewing, keyPair.Expiry)
+		}
 		reqLogger.Info("Updated service with new certificate")
 	}
 
 	return reconcile.Result{}, nil
 }
+
+// writeSecret writes keyPair to the certificate secret of svc, in the format requested by its
+// annotations and owned by svc
+func (r *ReconcileService) writeSecret(svc *corev1.Service, keyPair certs.KeyPair) error {
+	pkcs12 := svc.ObjectMeta.Annotations[r.config.General.Annotations.Format] == r.config.General.Annotations.Pkcs12Format
+	dm, secretType, err := helpers.SecretData(keyPair, pkcs12)
+	if err != nil {
+		return err
+	}
+
+	certSec := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      helpers.ServiceSecretName(svc.ObjectMeta.Name),
+			Namespace: svc.ObjectMeta.Namespace,
+		},
+		Data: dm,
+		Type: secretType,
+	}
+	// owned by the service, so it is garbage collected with it and changes to it are noticed
+	err = controllerutil.SetControllerReference(svc, certSec, r.scheme)
+	if err != nil {
+		return err
+	}
+
+	return helpers.Apply(r.client, certSec)
+}
+
+// revoke revokes the certificate the operator issued for a deleted service and releases the service
+func (r *ReconcileService) revoke(svc *corev1.Service) error {
+	if !helpers.HasFinalizer(svc, helpers.Finalizer) {
+		return nil
+	}
+	reqLogger := log.WithValues("Request.Namespace", svc.Namespace, "Request.Name", svc.Name)
+
+	secret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: svc.Namespace, Name: helpers.ServiceSecretName(svc.Name)}, secret)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && len(secret.Data["tls.crt"]) > 0 {
+		if err := helpers.Revoke(r.ctx, r.issuers, svc, secret.Data["tls.crt"]); err != nil {
+			reqLogger.Error(err, "Failed to revoke certificate")
+			return err
+		}
+		reqLogger.Info("Revoked certificate of deleted service")
+	}
+
+	helpers.RemoveFinalizer(svc, helpers.Finalizer)
+	return r.client.Update(context.TODO(), svc)
+}
+
+// secretIntact reports whether the certificate secret of svc still holds the certificate
+// recorded in its expiry annotation
+func (r *ReconcileService) secretIntact(svc *corev1.Service) (bool, error) {
+	secret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: svc.Namespace, Name: helpers.ServiceSecretName(svc.Name)}, secret)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	pkcs12 := svc.ObjectMeta.Annotations[r.config.General.Annotations.Format] == r.config.General.Annotations.Pkcs12Format
+	cert := helpers.SecretCert(secret, pkcs12)
+	if cert == nil {
+		return false, nil
+	}
+	return cert.NotAfter.UTC().Format(helpers.TimeFormat) == svc.ObjectMeta.Annotations[r.config.General.Annotations.Expiry], nil
+}
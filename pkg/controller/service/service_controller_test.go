@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/redhat-cop/cert-operator/pkg/certs"
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	"github.com/redhat-cop/cert-operator/pkg/helpers"
+	"github.com/redhat-cop/cert-operator/pkg/issuer"
+	"github.com/redhat-cop/cert-operator/pkg/notifier"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// failingProvider refuses every request
+type failingProvider struct{}
+
+func (p *failingProvider) Provision(ctx context.Context, req certs.CertificateRequest) (certs.KeyPair, error) {
+	return certs.KeyPair{}, certs.NewCertError("request denied")
+}
+
+func (p *failingProvider) Deprovision(ctx context.Context, cert []byte) error {
+	return nil
+}
+
+// newTestReconciler returns a reconciler issuing certificates with provider for objs
+func newTestReconciler(t *testing.T, provider certs.Provider, objs ...runtime.Object) *ReconcileService {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewFakeClientWithScheme(scheme, objs...)
+	config := certconf.DefaultConfig()
+
+	notifications, err := notifier.NewDispatcher(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &ReconcileService{
+		client:        c,
+		scheme:        scheme,
+		config:        config,
+		issuers:       issuer.NewStaticResolver(c, config, issuer.Issuer{Provider: provider, Kind: "test"}),
+		ctx:           context.TODO(),
+		recorder:      record.NewFakeRecorder(10),
+		notifications: notifications,
+	}
+}
+
+func newPKCS12Service(config certconf.Config) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "example",
+			Namespace: "test",
+			Annotations: map[string]string{
+				config.General.Annotations.Status: config.General.Annotations.NeedCertValue,
+				config.General.Annotations.Format: config.General.Annotations.Pkcs12Format,
+			},
+		},
+		Spec: corev1.ServiceSpec{ClusterIP: corev1.ClusterIPNone},
+	}
+}
+
+var request = reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "test", Name: "example"}}
+
+func TestReconcileIssuesPKCS12(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	r := newTestReconciler(t, new(certs.SelfSignedProvider), newPKCS12Service(config))
+
+	// act
+	_, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := &corev1.Service{}
+	if err := r.client.Get(context.TODO(), request.NamespacedName, svc); err != nil {
+		t.Fatal(err)
+	}
+	if status := svc.Annotations[config.General.Annotations.Status]; status != "secured" {
+		t.Fatalf("expected status secured, got %s", status)
+	}
+
+	secret := &corev1.Secret{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: "test", Name: helpers.ServiceSecretName("example")}, secret); err != nil {
+		t.Fatal(err)
+	}
+	if len(secret.Data["tls.p12"]) == 0 {
+		t.Fatal("no PKCS12 bundle in the secret")
+	}
+}
+
+func TestReconcileFailedPKCS12(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	r := newTestReconciler(t, new(failingProvider), newPKCS12Service(config))
+
+	// act
+	_, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := &corev1.Service{}
+	if err := r.client.Get(context.TODO(), request.NamespacedName, svc); err != nil {
+		t.Fatal(err)
+	}
+	if status := svc.Annotations[config.General.Annotations.Status]; status != "failed" {
+		t.Fatalf("expected status failed, got %s", status)
+	}
+	if reason := svc.Annotations[config.General.Annotations.StatusReason]; reason != "request denied" {
+		t.Fatalf("expected the provider error as reason, got %s", reason)
+	}
+
+	err = r.client.Get(context.TODO(), types.NamespacedName{Namespace: "test", Name: helpers.ServiceSecretName("example")}, &corev1.Secret{})
+	if !errors.IsNotFound(err) {
+		t.Fatal("secret was written without a certificate")
+	}
+}
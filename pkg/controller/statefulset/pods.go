@@ -0,0 +1,62 @@
+package statefulset
+
+import (
+	"strconv"
+	"strings"
+
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	appsv1 "k8s.io/api/apps/v1"
+)
+
+const (
+	// statefulSetLabel and ordinalLabel mark the Secret of each pod, so the Secrets of removed
+	// ordinals can be found
+	statefulSetLabel = "openshift.io/cert-ctl-statefulset"
+	ordinalLabel     = "openshift.io/cert-ctl-ordinal"
+)
+
+// replicas returns the number of pods of sts, which defaults to one
+func replicas(sts *appsv1.StatefulSet) int {
+	if sts.Spec.Replicas == nil {
+		return 1
+	}
+	return int(*sts.Spec.Replicas)
+}
+
+// podName returns the name of the pod of sts with ordinal
+func podName(sts *appsv1.StatefulSet, ordinal int) string {
+	return sts.Name + "-" + strconv.Itoa(ordinal)
+}
+
+// podSecretName returns the name of the Secret the certificate of the pod with ordinal is written to
+func podSecretName(sts *appsv1.StatefulSet, ordinal int) string {
+	return podName(sts, ordinal) + "-certificate"
+}
+
+// podPendingSecretName returns the name of the Secret a pending request for the certificate of
+// the pod with ordinal is kept in
+func podPendingSecretName(sts *appsv1.StatefulSet, ordinal int) string {
+	return podSecretName(sts, ordinal) + "-pending-key"
+}
+
+// podHosts returns the names the certificate of the pod of sts with ordinal is issued for: every
+// form of its DNS name in the headless service and the names listed in the extra-sans annotation.
+// <pod>.<service>.<namespace>.svc comes first and so becomes the common name.
+func podHosts(config certconf.Config, sts *appsv1.StatefulSet, ordinal int) []string {
+	pod := podName(sts, ordinal)
+	service := sts.Spec.ServiceName
+	namespace := sts.Namespace
+
+	hosts := []string{pod + "." + service + "." + namespace + ".svc"}
+	if len(config.General.ClusterDomain) > 0 {
+		hosts = append(hosts, pod+"."+service+"."+namespace+".svc."+strings.Trim(config.General.ClusterDomain, "."))
+	}
+	hosts = append(hosts, pod+"."+service+"."+namespace, pod+"."+service)
+
+	for _, san := range strings.Split(sts.ObjectMeta.Annotations[config.General.Annotations.ExtraSANs], ",") {
+		if san = strings.TrimSpace(san); len(san) > 0 {
+			hosts = append(hosts, san)
+		}
+	}
+	return hosts
+}
@@ -0,0 +1,59 @@
+package statefulset
+
+import (
+	"reflect"
+	"testing"
+
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReplicas(t *testing.T) {
+	three := int32(3)
+	zero := int32(0)
+	tests := []struct {
+		replicas *int32
+		expected int
+	}{
+		{nil, 1},
+		{&three, 3},
+		{&zero, 0},
+	}
+	for _, test := range tests {
+		sts := &appsv1.StatefulSet{Spec: appsv1.StatefulSetSpec{Replicas: test.replicas}}
+		if count := replicas(sts); count != test.expected {
+			t.Errorf("expected %d replicas, got %d", test.expected, count)
+		}
+	}
+}
+
+func TestPodHosts(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kafka",
+			Namespace: "test",
+			Annotations: map[string]string{
+				config.General.Annotations.ExtraSANs: " kafka.example.com,,",
+			},
+		},
+		Spec: appsv1.StatefulSetSpec{ServiceName: "kafka-headless"},
+	}
+
+	// act
+	hosts := podHosts(config, sts, 2)
+
+	// assert
+	expected := []string{
+		"kafka-2.kafka-headless.test.svc",
+		"kafka-2.kafka-headless.test.svc.cluster.local",
+		"kafka-2.kafka-headless.test",
+		"kafka-2.kafka-headless",
+		"kafka.example.com",
+	}
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Fatalf("expected %v, got %v", expected, hosts)
+	}
+}
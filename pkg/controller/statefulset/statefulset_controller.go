@@ -0,0 +1,402 @@
+package statefulset
+
+import (
+	"context"
+	"crypto/x509"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/redhat-cop/cert-operator/pkg/certs"
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	"github.com/redhat-cop/cert-operator/pkg/helpers"
+	"github.com/redhat-cop/cert-operator/pkg/issuer"
+	"github.com/redhat-cop/cert-operator/pkg/metrics"
+	"github.com/redhat-cop/cert-operator/pkg/notifier"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+var log = logf.Log.WithName("controller_statefulset")
+
+// Add creates a new StatefulSet Controller and adds it to the Manager. The Manager will set fields on the Controller
+// and Start it when the Manager is Started.
+func Add(mgr manager.Manager, config certconf.Config, issuers *issuer.Resolver, notifications *notifier.Dispatcher) error {
+	return add(mgr, newReconciler(mgr, config, issuers, notifications))
+}
+
+// newReconciler returns a new reconcile.Reconciler
+func newReconciler(mgr manager.Manager, config certconf.Config, issuers *issuer.Resolver,
+	notifications *notifier.Dispatcher) reconcile.Reconciler {
+	ctx, err := helpers.ManagerContext(mgr)
+	if err != nil {
+		panic("There was a problem registering the shutdown handler. \n" +
+			"\t" + err.Error())
+	}
+
+	return &ReconcileStatefulSet{client: mgr.GetClient(), scheme: mgr.GetScheme(), config: config, issuers: issuers, ctx: ctx,
+		recorder: mgr.GetRecorder("statefulset-controller"), notifications: notifications}
+}
+
+// add adds a new Controller to mgr with r as the reconcile.Reconciler
+func add(mgr manager.Manager, r reconcile.Reconciler) error {
+	// Create a new controller
+	c, err := controller.New("statefulset-controller", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to primary resource StatefulSet, which include scaling it
+	err = c.Watch(&source.Kind{Type: &appsv1.StatefulSet{}}, &handler.EnqueueRequestForObject{})
+	if err != nil {
+		return err
+	}
+
+	// Watch for changes to secondary resource Secrets and requeue the owner StatefulSet
+	err = c.Watch(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestForOwner{
+		IsController: true,
+		OwnerType:    &appsv1.StatefulSet{},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+var _ reconcile.Reconciler = &ReconcileStatefulSet{}
+
+// ReconcileStatefulSet reconciles a StatefulSet object
+type ReconcileStatefulSet struct {
+	// This client, initialized using mgr.Client() above, is a split client
+	// that reads objects from the cache and writes to the apiserver
+	client  client.Client
+	scheme  *runtime.Scheme
+	config  certconf.Config
+	issuers *issuer.Resolver
+	// ctx is cancelled when the manager stops, abandoning in-flight provider calls
+	ctx           context.Context
+	recorder      record.EventRecorder
+	notifications *notifier.Dispatcher
+}
+
+// Reconcile issues a certificate for every pod of an annotated StatefulSet into a Secret of its
+// own, renews them one by one and removes the Secrets of pods that were scaled away.
+// Note:
+// The Controller will requeue the Request to be processed again if the returned error is non-nil or
+// Result.Requeue is true, otherwise upon completion it will remove the work from the queue.
+func (r *ReconcileStatefulSet) Reconcile(request reconcile.Request) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
+
+	// Fetch the StatefulSet
+	sts := &appsv1.StatefulSet{}
+	err := r.client.Get(context.TODO(), request.NamespacedName, sts)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// Request object not found, could have been deleted after reconcile request.
+			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
+			// Return and don't requeue
+			metrics.DeleteExpiry("StatefulSet", request.Namespace, request.Name)
+			return reconcile.Result{}, nil
+		}
+		// Error reading the object - requeue the request.
+		return reconcile.Result{}, err
+	}
+
+	if sts.GetDeletionTimestamp() != nil {
+		return reconcile.Result{}, r.revoke(sts)
+	}
+
+	// Look for annotation that requires action, otherwise skip it
+	if sts.ObjectMeta.Annotations == nil || sts.ObjectMeta.Annotations[r.config.General.Annotations.Status] == "" {
+		// the annotation may have been removed from an object that had a certificate
+		metrics.DeleteExpiry("StatefulSet", request.Namespace, request.Name)
+		return reconcile.Result{}, nil
+	}
+
+	// pods come and go with the replicas, so a secured StatefulSet is checked on every change
+	status := sts.ObjectMeta.Annotations[r.config.General.Annotations.Status]
+	if status != r.config.General.Annotations.NeedCertValue && status != helpers.StatusPending && status != "secured" {
+		return reconcile.Result{}, nil
+	}
+	original := sts.DeepCopy()
+
+	if len(sts.Spec.ServiceName) == 0 {
+		// nothing to retry until the StatefulSet is fixed
+		err = certs.NewErrBadHost("StatefulSet has no serviceName, its pods have no DNS names")
+		r.recorder.Event(sts, corev1.EventTypeWarning, helpers.EventIssueFailed, err.Error())
+		return reconcile.Result{}, r.setStatus(sts, original, "failed", err.Error())
+	}
+
+	iss, err := r.issuers.ForObject(r.ctx, sts)
+	if err != nil {
+		r.recorder.Event(sts, corev1.EventTypeWarning, helpers.EventProviderUnavailable, err.Error())
+		return r.failed(sts, original, status, err, true)
+	}
+
+	pkcs12 := sts.ObjectMeta.Annotations[r.config.General.Annotations.Format] == r.config.General.Annotations.Pkcs12Format
+	var expiry, nextRenewal time.Time
+	// a pod whose certificate cannot be issued does not hold up the others, the first error is
+	// reported once every pod has been seen to
+	var failErr error
+	renewalFailed := true
+	pending := false
+	for ordinal := 0; ordinal < replicas(sts); ordinal++ {
+		hosts := podHosts(r.config, sts, ordinal)
+
+		secret := &corev1.Secret{}
+		err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: sts.Namespace, Name: podSecretName(sts, ordinal)}, secret)
+		if err != nil && !errors.IsNotFound(err) {
+			return reconcile.Result{}, err
+		}
+		// a Secret the StatefulSet does not own holds no certificate of the pod, issue reports it
+		var cert *x509.Certificate
+		if err == nil && metav1.IsControlledBy(secret, sts) {
+			cert = helpers.SecretCert(secret, pkcs12)
+		}
+
+		renewAt := time.Time{}
+		if cert != nil {
+			renewAt = r.config.General.Renewal.RenewAt(cert.NotBefore, cert.NotAfter)
+		}
+		if cert != nil && !helpers.HostsChanged(r.config, secret, hosts) && time.Until(renewAt) > 0 {
+			expiry = earliest(expiry, cert.NotAfter)
+			nextRenewal = earliest(nextRenewal, renewAt)
+			continue
+		}
+
+		// a certificate that is being renewed stays in place until its successor is issued
+		renewing := cert != nil
+		reqLogger.Info("Issuing certificate for pod", "Pod", podName(sts, ordinal))
+		keyPair, issued, err := r.issue(sts, ordinal, hosts, iss, pkcs12)
+		if r.ctx.Err() != nil {
+			// shutting down, leave the status alone so the next leader picks it up
+			return reconcile.Result{}, r.ctx.Err()
+		}
+		if err != nil || !issued {
+			if cert != nil {
+				expiry = earliest(expiry, cert.NotAfter)
+			}
+			if err == nil {
+				pending = true
+				continue
+			}
+			r.recorder.Event(sts, corev1.EventTypeWarning, helpers.EventIssueFailed, "Pod "+podName(sts, ordinal)+": "+err.Error())
+			certExpiry := time.Time{}
+			if cert != nil {
+				certExpiry = cert.NotAfter
+			}
+			helpers.NotifyFailed(r.ctx, r.notifications, r.config, "StatefulSet", sts, hosts, certExpiry, err)
+			if failErr == nil {
+				failErr = err
+			}
+			renewalFailed = renewalFailed && renewing
+			continue
+		}
+
+		r.recorder.Eventf(sts, corev1.EventTypeNormal, helpers.IssuedReason(renewing), "Certificate for pod %s issued, valid until %s", podName(sts, ordinal), keyPair.Expiry.UTC().Format(helpers.TimeFormat))
+		helpers.NotifyIssued(r.ctx, r.notifications, "StatefulSet", sts, hosts, renewing, keyPair.Expiry)
+		// the certificates issued so far are revoked along with the StatefulSet, whatever
+		// becomes of the other pods
+		helpers.AddFinalizer(sts, helpers.Finalizer)
+		expiry = earliest(expiry, keyPair.Expiry)
+		nextRenewal = earliest(nextRenewal, r.config.General.Renewal.RenewAt(time.Now(), keyPair.Expiry))
+	}
+
+	if err := r.removeOrdinals(sts, replicas(sts)); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// the expiry annotation holds the certificate that expires first
+	if expiry.IsZero() {
+		delete(sts.ObjectMeta.Annotations, r.config.General.Annotations.Expiry)
+		metrics.DeleteExpiry("StatefulSet", sts.Namespace, sts.Name)
+	} else {
+		sts.ObjectMeta.Annotations[r.config.General.Annotations.Expiry] = expiry.UTC().Format(helpers.TimeFormat)
+		metrics.SetExpiry("StatefulSet", sts.Namespace, sts.Name, expiry)
+	}
+
+	if failErr != nil {
+		return r.failed(sts, original, status, failErr, renewalFailed)
+	}
+	if pending {
+		// the CA has not issued every certificate yet, collect them on a later reconcile
+		reqLogger.Info("Waiting for certificates to be issued")
+		if err := r.setStatus(sts, original, helpers.StatusPending, ""); err != nil {
+			return reconcile.Result{}, err
+		}
+		return reconcile.Result{RequeueAfter: r.config.General.PollDuration()}, nil
+	}
+	if err := r.setStatus(sts, original, "secured", ""); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if nextRenewal.IsZero() {
+		return reconcile.Result{}, nil
+	}
+	return reconcile.Result{RequeueAfter: helpers.RequeueAfter(nextRenewal)}, nil
+}
+
+// failed records that a certificate could not be issued. A StatefulSet whose pods were secured
+// before stays secured when only renewals failed, its pods keep their certificates and the
+// request is retried with backoff.
+func (r *ReconcileStatefulSet) failed(sts *appsv1.StatefulSet, original *appsv1.StatefulSet, status string, err error, renewal bool) (reconcile.Result, error) {
+	if status == "secured" && renewal {
+		if updateErr := r.setStatus(sts, original, "secured", "Renewal failed: "+err.Error()); updateErr != nil {
+			return reconcile.Result{}, updateErr
+		}
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{}, r.setStatus(sts, original, "failed", err.Error())
+}
+
+// setStatus sets the status and status-reason annotations of sts and saves it if anything
+// changed since original was read
+func (r *ReconcileStatefulSet) setStatus(sts *appsv1.StatefulSet, original *appsv1.StatefulSet, status string, reason string) error {
+	sts.ObjectMeta.Annotations[r.config.General.Annotations.Status] = status
+	if len(reason) > 0 {
+		sts.ObjectMeta.Annotations[r.config.General.Annotations.StatusReason] = reason
+	} else {
+		delete(sts.ObjectMeta.Annotations, r.config.General.Annotations.StatusReason)
+	}
+
+	// saving the StatefulSet brings it back, only do so when there is something to save
+	if reflect.DeepEqual(original.ObjectMeta, sts.ObjectMeta) {
+		return nil
+	}
+	return r.client.Update(context.TODO(), sts)
+}
+
+// issue obtains a certificate for the pod of sts with ordinal and writes it to the Secret of the
+// pod, owned by sts. issued is false while the CA has not issued it yet; every pod has a pending
+// request of its own, kept in the Secret podPendingSecretName.
+func (r *ReconcileStatefulSet) issue(sts *appsv1.StatefulSet, ordinal int, hosts []string, iss issuer.Issuer, pkcs12 bool) (certs.KeyPair, bool, error) {
+	// the Secret of the pod is only written when it is new or owned by sts, so a Secret of the
+	// same name someone else created is not overwritten
+	existing := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: sts.Namespace, Name: podSecretName(sts, ordinal)}, existing)
+	if err != nil && !errors.IsNotFound(err) {
+		return certs.KeyPair{}, false, err
+	}
+	if err == nil && !metav1.IsControlledBy(existing, sts) {
+		return certs.KeyPair{}, false, certs.NewCertError("secret " + existing.Name + " exists and is not owned by the StatefulSet")
+	}
+
+	certReq := certs.NewCertificateRequest(hosts...)
+	certReq.Options[certs.OptionNamespace] = sts.Namespace
+	certReq.Duration = iss.CertDuration(r.config.General.CertDuration())
+
+	keyPair, issued, err := helpers.ObtainPendingCert(r.ctx, r.client, r.scheme, sts, certReq, iss.Provider, podPendingSecretName(sts, ordinal))
+	metrics.ObserveIssue(iss.Kind, sts.Namespace, issued, err)
+	if err != nil || !issued {
+		return certs.KeyPair{}, false, err
+	}
+
+	dm, secretType, err := helpers.SecretData(keyPair, pkcs12)
+	if err != nil {
+		return certs.KeyPair{}, false, err
+	}
+
+	certSec := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      podSecretName(sts, ordinal),
+			Namespace: sts.Namespace,
+			Labels: map[string]string{
+				statefulSetLabel: sts.Name,
+				ordinalLabel:     strconv.Itoa(ordinal),
+			},
+			Annotations: map[string]string{},
+		},
+		Data: dm,
+		Type: secretType,
+	}
+	helpers.SetHosts(r.config, certSec, certReq.Hosts())
+	// owned by the StatefulSet, so it is garbage collected with it and changes to it are noticed
+	err = controllerutil.SetControllerReference(sts, certSec, r.scheme)
+	if err != nil {
+		return certs.KeyPair{}, false, err
+	}
+
+	if err := helpers.Apply(r.client, certSec); err != nil {
+		return certs.KeyPair{}, false, err
+	}
+	return keyPair, true, nil
+}
+
+// removeOrdinals revokes and deletes the certificates of the pods of sts with an ordinal of
+// count or more, which are no longer part of it
+func (r *ReconcileStatefulSet) removeOrdinals(sts *appsv1.StatefulSet, count int) error {
+	reqLogger := log.WithValues("Request.Namespace", sts.Namespace, "Request.Name", sts.Name)
+
+	secrets := &corev1.SecretList{}
+	selector := labels.SelectorFromSet(labels.Set{statefulSetLabel: sts.Name})
+	if err := r.client.List(context.TODO(), &client.ListOptions{Namespace: sts.Namespace, LabelSelector: selector}, secrets); err != nil {
+		return err
+	}
+
+	for i := range secrets.Items {
+		secret := &secrets.Items[i]
+		ordinal, err := strconv.Atoi(secret.Labels[ordinalLabel])
+		if err != nil || ordinal < count || !metav1.IsControlledBy(secret, sts) {
+			continue
+		}
+
+		if len(secret.Data["tls.crt"]) > 0 {
+			if err := helpers.Revoke(r.ctx, r.issuers, sts, secret.Data["tls.crt"]); err != nil {
+				reqLogger.Error(err, "Failed to revoke certificate", "Secret", secret.Name)
+				return err
+			}
+		}
+		if err := r.client.Delete(context.TODO(), secret); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		// along with a renewal that may still be pending
+		pendingSecret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: sts.Namespace, Name: podPendingSecretName(sts, ordinal)}}
+		if err := r.client.Delete(context.TODO(), pendingSecret); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		reqLogger.Info("Removed certificate of pod that is gone", "Secret", secret.Name)
+	}
+	return nil
+}
+
+// revoke revokes the certificates the operator issued for the pods of a deleted StatefulSet and
+// releases the StatefulSet
+func (r *ReconcileStatefulSet) revoke(sts *appsv1.StatefulSet) error {
+	if !helpers.HasFinalizer(sts, helpers.Finalizer) {
+		return nil
+	}
+
+	if err := r.removeOrdinals(sts, 0); err != nil {
+		return err
+	}
+
+	helpers.RemoveFinalizer(sts, helpers.Finalizer)
+	return r.client.Update(context.TODO(), sts)
+}
+
+// earliest returns the earlier of t and u, ignoring t while it is zero
+func earliest(t time.Time, u time.Time) time.Time {
+	if t.IsZero() || u.Before(t) {
+		return u
+	}
+	return t
+}
@@ -0,0 +1,277 @@
+package statefulset
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/redhat-cop/cert-operator/pkg/certs"
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	"github.com/redhat-cop/cert-operator/pkg/helpers"
+	"github.com/redhat-cop/cert-operator/pkg/issuer"
+	"github.com/redhat-cop/cert-operator/pkg/notifier"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// revokingProvider issues self-signed certificates, refuses those for the hosts in deny and
+// remembers the ones it revoked
+type revokingProvider struct {
+	certs.SelfSignedProvider
+	deny    map[string]bool
+	revoked []string
+}
+
+func (p *revokingProvider) Provision(ctx context.Context, req certs.CertificateRequest) (certs.KeyPair, error) {
+	if p.deny[req.CommonName()] {
+		return certs.KeyPair{}, certs.NewCertError("request denied")
+	}
+	return p.SelfSignedProvider.Provision(ctx, req)
+}
+
+func (p *revokingProvider) Deprovision(ctx context.Context, cert []byte) error {
+	p.revoked = append(p.revoked, string(cert))
+	return nil
+}
+
+var request = reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "test", Name: "kafka"}}
+
+func newTestReconciler(t *testing.T, provider certs.Provider, objs ...runtime.Object) *ReconcileStatefulSet {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := appsv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewFakeClientWithScheme(scheme, objs...)
+	config := certconf.DefaultConfig()
+
+	notifications, err := notifier.NewDispatcher(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &ReconcileStatefulSet{
+		client:        c,
+		scheme:        scheme,
+		config:        config,
+		issuers:       issuer.NewStaticResolver(c, config, issuer.Issuer{Provider: provider, Kind: "test"}),
+		ctx:           context.TODO(),
+		recorder:      record.NewFakeRecorder(10),
+		notifications: notifications,
+	}
+}
+
+func newTestStatefulSet(config certconf.Config, count int32) *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kafka",
+			Namespace: "test",
+			Annotations: map[string]string{
+				config.General.Annotations.Status: config.General.Annotations.NeedCertValue,
+			},
+		},
+		Spec: appsv1.StatefulSetSpec{Replicas: &count, ServiceName: "kafka-headless"},
+	}
+}
+
+// scale sets the replicas of the StatefulSet and reconciles it
+func scale(t *testing.T, r *ReconcileStatefulSet, count int32) *appsv1.StatefulSet {
+	sts := &appsv1.StatefulSet{}
+	if err := r.client.Get(context.TODO(), request.NamespacedName, sts); err != nil {
+		t.Fatal(err)
+	}
+	sts.Spec.Replicas = &count
+	if err := r.client.Update(context.TODO(), sts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(request); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.client.Get(context.TODO(), request.NamespacedName, sts); err != nil {
+		t.Fatal(err)
+	}
+	return sts
+}
+
+func getPodSecret(r *ReconcileStatefulSet, name string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: "test", Name: name}, secret)
+	return secret, err
+}
+
+func TestReconcileIssuesPerPod(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	r := newTestReconciler(t, new(revokingProvider), newTestStatefulSet(config, 2))
+
+	// act
+	sts := scale(t, r, 2)
+
+	// assert
+	if status := sts.Annotations[config.General.Annotations.Status]; status != "secured" {
+		t.Fatalf("expected status secured, got %s", status)
+	}
+	for ordinal, name := range []string{"kafka-0-certificate", "kafka-1-certificate"} {
+		secret, err := getPodSecret(r, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert := helpers.SecretCert(secret, false)
+		if cert == nil || cert.Subject.CommonName != podHosts(config, sts, ordinal)[0] {
+			t.Fatalf("secret %s does not hold the certificate of its pod", name)
+		}
+	}
+	if !helpers.HasFinalizer(sts, helpers.Finalizer) {
+		t.Fatal("finalizer was not added")
+	}
+}
+
+func TestReconcileScaleDownRevokes(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	provider := new(revokingProvider)
+	r := newTestReconciler(t, provider, newTestStatefulSet(config, 3))
+	scale(t, r, 3)
+	removed, err := getPodSecret(r, "kafka-2-certificate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	sts := scale(t, r, 2)
+
+	// assert
+	if status := sts.Annotations[config.General.Annotations.Status]; status != "secured" {
+		t.Fatalf("expected status secured, got %s", status)
+	}
+	if _, err := getPodSecret(r, "kafka-2-certificate"); !errors.IsNotFound(err) {
+		t.Fatal("secret of the removed pod was not deleted")
+	}
+	if _, err := getPodSecret(r, "kafka-1-certificate"); err != nil {
+		t.Fatal(err)
+	}
+	if len(provider.revoked) != 1 || provider.revoked[0] != string(removed.Data["tls.crt"]) {
+		t.Fatalf("expected the certificate of the removed pod to be revoked, got %d revoked", len(provider.revoked))
+	}
+}
+
+func TestRemoveOrdinals(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	provider := new(revokingProvider)
+	sts := newTestStatefulSet(config, 2)
+	foreign := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kafka-5-certificate",
+			Namespace: "test",
+			Labels:    map[string]string{statefulSetLabel: "kafka", ordinalLabel: "5"},
+		},
+	}
+	r := newTestReconciler(t, provider, sts, foreign)
+	scale(t, r, 2)
+	if err := r.client.Get(context.TODO(), request.NamespacedName, sts); err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	err := r.removeOrdinals(sts, 1)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getPodSecret(r, "kafka-0-certificate"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getPodSecret(r, "kafka-1-certificate"); !errors.IsNotFound(err) {
+		t.Fatal("secret of ordinal 1 was not deleted")
+	}
+	if _, err := getPodSecret(r, "kafka-5-certificate"); err != nil {
+		t.Fatal("secret not owned by the StatefulSet was deleted")
+	}
+	if len(provider.revoked) != 1 {
+		t.Fatalf("expected one revoked certificate, got %d", len(provider.revoked))
+	}
+}
+
+func TestReconcileFailedPodKeepsOthers(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	provider := &revokingProvider{deny: map[string]bool{"kafka-1.kafka-headless.test.svc": true}}
+	r := newTestReconciler(t, provider, newTestStatefulSet(config, 3))
+
+	// act
+	_, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	sts := &appsv1.StatefulSet{}
+	if err := r.client.Get(context.TODO(), request.NamespacedName, sts); err != nil {
+		t.Fatal(err)
+	}
+	if status := sts.Annotations[config.General.Annotations.Status]; status != "failed" {
+		t.Fatalf("expected status failed, got %s", status)
+	}
+	for _, name := range []string{"kafka-0-certificate", "kafka-2-certificate"} {
+		if _, err := getPodSecret(r, name); err != nil {
+			t.Fatalf("secret %s was not written: %v", name, err)
+		}
+	}
+	// the certificates that were issued are revoked with the StatefulSet
+	if !helpers.HasFinalizer(sts, helpers.Finalizer) {
+		t.Fatal("finalizer was not added")
+	}
+}
+
+func TestReconcileForeignSecretNotOverwritten(t *testing.T) {
+	// setup
+	config := certconf.DefaultConfig()
+	foreign := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "kafka-1-certificate", Namespace: "test"},
+		Data:       map[string][]byte{"tls.crt": []byte("certificate set by hand")},
+	}
+	r := newTestReconciler(t, new(revokingProvider), newTestStatefulSet(config, 2), foreign)
+
+	// act
+	_, err := r.Reconcile(request)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	sts := &appsv1.StatefulSet{}
+	if err := r.client.Get(context.TODO(), request.NamespacedName, sts); err != nil {
+		t.Fatal(err)
+	}
+	if status := sts.Annotations[config.General.Annotations.Status]; status != "failed" {
+		t.Fatalf("expected status failed, got %s", status)
+	}
+	secret, err := getPodSecret(r, "kafka-1-certificate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data["tls.crt"]) != "certificate set by hand" || len(secret.OwnerReferences) > 0 {
+		t.Fatal("secret not owned by the StatefulSet was overwritten")
+	}
+	if _, err := getPodSecret(r, "kafka-0-certificate"); err != nil {
+		t.Fatalf("secret of the other pod was not written: %v", err)
+	}
+	events := r.recorder.(*record.FakeRecorder).Events
+	for len(events) > 0 {
+		if event := <-events; strings.Contains(event, " "+helpers.EventIssueFailed+" Pod kafka-1:") {
+			return
+		}
+	}
+	t.Fatalf("expected a %s event for pod kafka-1", helpers.EventIssueFailed)
+}
@@ -0,0 +1,171 @@
+package helpers
+
+import (
+	"context"
+
+	"github.com/redhat-cop/cert-operator/pkg/certs"
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	StatusPending = "pending"
+
+	pendingKeyName       = "tls.key"
+	pendingRequestIDName = "request-id"
+)
+
+// ObtainCert gets a certificate for obj from the provider. Providers that issue synchronously
+// are simply called. For an AsyncProvider the first call submits the request, records the
+// pickup ID in the request-id annotation of obj and keeps the private key in the Secret named
+// pendingSecret; later calls try to collect the certificate. issued is false while the CA has
+// not issued it yet. The pending Secret is owned by obj. The caller is responsible for saving
+// the annotations of obj.
+func ObtainCert(ctx context.Context, c client.Client, scheme *runtime.Scheme, config certconf.Config, obj metav1.Object, req certs.CertificateRequest, provider certs.Provider, pendingSecret string) (keyPair certs.KeyPair, issued bool, err error) {
+	async, ok := provider.(certs.AsyncProvider)
+	if !ok {
+		keyPair, err = GetCert(ctx, req, provider)
+		return keyPair, err == nil, err
+	}
+
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	requestID := annotations[config.General.Annotations.RequestID]
+
+	if len(requestID) == 0 {
+		req, err = withDefaultDuration(req)
+		if err != nil {
+			return certs.KeyPair{}, false, err
+		}
+
+		pending, err := async.Submit(ctx, req)
+		if err != nil {
+			return certs.KeyPair{}, false, err
+		}
+
+		secret := &corev1.Secret{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "Secret",
+				APIVersion: "v1",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      pendingSecret,
+				Namespace: obj.GetNamespace(),
+			},
+			Data: map[string][]byte{
+				pendingKeyName: pending.Key,
+			},
+			Type: corev1.SecretTypeOpaque,
+		}
+		if err := controllerutil.SetControllerReference(obj, secret, scheme); err != nil {
+			return certs.KeyPair{}, false, err
+		}
+		if err := Apply(c, secret); err != nil {
+			return certs.KeyPair{}, false, err
+		}
+
+		annotations[config.General.Annotations.RequestID] = pending.ID
+		obj.SetAnnotations(annotations)
+		return certs.KeyPair{}, false, nil
+	}
+
+	secret := &corev1.Secret{}
+	err = c.Get(ctx, types.NamespacedName{Namespace: obj.GetNamespace(), Name: pendingSecret}, secret)
+	if errors.IsNotFound(err) {
+		// the key is gone, the request cannot be completed and has to be made again
+		delete(annotations, config.General.Annotations.RequestID)
+		obj.SetAnnotations(annotations)
+		return certs.KeyPair{}, false, certs.NewCertError("private key for pending request " + requestID + " was lost")
+	}
+	if err != nil {
+		return certs.KeyPair{}, false, err
+	}
+
+	keyPair, err = async.Retrieve(ctx, certs.PendingRequest{ID: requestID, Key: secret.Data[pendingKeyName]})
+	if _, pending := err.(*certs.ErrCertificatePending); pending || ctx.Err() != nil {
+		return certs.KeyPair{}, false, ctx.Err()
+	}
+
+	// the request is finished one way or the other
+	delete(annotations, config.General.Annotations.RequestID)
+	obj.SetAnnotations(annotations)
+	if deleteErr := c.Delete(ctx, secret); deleteErr != nil && !errors.IsNotFound(deleteErr) {
+		return certs.KeyPair{}, false, deleteErr
+	}
+
+	if err != nil {
+		return certs.KeyPair{}, false, err
+	}
+	return keyPair, true, nil
+}
+
+// ObtainPendingCert is ObtainCert for objects that hold several certificates, whose pending
+// requests cannot all be recorded in the request-id annotation. The pickup ID is kept in the
+// pending Secret along with the private key instead, and the annotations of obj are left alone.
+func ObtainPendingCert(ctx context.Context, c client.Client, scheme *runtime.Scheme, obj metav1.Object, req certs.CertificateRequest, provider certs.Provider, pendingSecret string) (keyPair certs.KeyPair, issued bool, err error) {
+	async, ok := provider.(certs.AsyncProvider)
+	if !ok {
+		keyPair, err = GetCert(ctx, req, provider)
+		return keyPair, err == nil, err
+	}
+
+	secret := &corev1.Secret{}
+	err = c.Get(ctx, types.NamespacedName{Namespace: obj.GetNamespace(), Name: pendingSecret}, secret)
+	if errors.IsNotFound(err) {
+		req, err = withDefaultDuration(req)
+		if err != nil {
+			return certs.KeyPair{}, false, err
+		}
+
+		pending, err := async.Submit(ctx, req)
+		if err != nil {
+			return certs.KeyPair{}, false, err
+		}
+
+		secret = &corev1.Secret{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       "Secret",
+				APIVersion: "v1",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      pendingSecret,
+				Namespace: obj.GetNamespace(),
+			},
+			Data: map[string][]byte{
+				pendingKeyName:       pending.Key,
+				pendingRequestIDName: []byte(pending.ID),
+			},
+			Type: corev1.SecretTypeOpaque,
+		}
+		if err := controllerutil.SetControllerReference(obj, secret, scheme); err != nil {
+			return certs.KeyPair{}, false, err
+		}
+		return certs.KeyPair{}, false, c.Create(ctx, secret)
+	}
+	if err != nil {
+		return certs.KeyPair{}, false, err
+	}
+
+	keyPair, err = async.Retrieve(ctx, certs.PendingRequest{ID: string(secret.Data[pendingRequestIDName]), Key: secret.Data[pendingKeyName]})
+	if _, pending := err.(*certs.ErrCertificatePending); pending || ctx.Err() != nil {
+		return certs.KeyPair{}, false, ctx.Err()
+	}
+
+	// the request is finished one way or the other
+	if deleteErr := c.Delete(ctx, secret); deleteErr != nil && !errors.IsNotFound(deleteErr) {
+		return certs.KeyPair{}, false, deleteErr
+	}
+
+	if err != nil {
+		return certs.KeyPair{}, false, err
+	}
+	return keyPair, true, nil
+}
@@ -0,0 +1,29 @@
+package helpers
+
+import (
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// stopper cancels a context when the manager it was added to stops
+type stopper struct {
+	cancel context.CancelFunc
+}
+
+func (s *stopper) Start(stop <-chan struct{}) error {
+	<-stop
+	s.cancel()
+	return nil
+}
+
+// ManagerContext returns a context that is cancelled when the manager shuts down, so that
+// in-flight provider calls are abandoned instead of holding up termination
+func ManagerContext(mgr manager.Manager) (context.Context, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := mgr.Add(&stopper{cancel: cancel}); err != nil {
+		cancel()
+		return nil, err
+	}
+	return ctx, nil
+}
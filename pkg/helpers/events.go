@@ -0,0 +1,21 @@
+package helpers
+
+// Reasons of the events recorded on the objects the operator secures
+const (
+	EventIssued              = "Issued"
+	EventRenewed             = "Renewed"
+	EventIssueFailed         = "IssueFailed"
+	EventProviderUnavailable = "ProviderUnavailable"
+	EventSynced              = "Synced"
+	EventSyncFailed          = "SyncFailed"
+	EventExpiring            = "Expiring"
+	EventIssuerIgnored       = "IssuerIgnored"
+)
+
+// IssuedReason returns the reason of the event recorded when a certificate is issued
+func IssuedReason(renewing bool) string {
+	if renewing {
+		return EventRenewed
+	}
+	return EventIssued
+}
@@ -0,0 +1,54 @@
+package helpers
+
+import (
+	"context"
+
+	"github.com/redhat-cop/cert-operator/pkg/issuer"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+var log = logf.Log.WithName("helpers")
+
+// Finalizer holds back the deletion of an object until its certificate has been revoked
+const Finalizer = "openshift.io/cert-ctl-revoke"
+
+func HasFinalizer(obj metav1.Object, finalizer string) bool {
+	for _, f := range obj.GetFinalizers() {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+func AddFinalizer(obj metav1.Object, finalizer string) {
+	if !HasFinalizer(obj, finalizer) {
+		obj.SetFinalizers(append(obj.GetFinalizers(), finalizer))
+	}
+}
+
+func RemoveFinalizer(obj metav1.Object, finalizer string) {
+	var finalizers []string
+	for _, f := range obj.GetFinalizers() {
+		if f != finalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	obj.SetFinalizers(finalizers)
+}
+
+// Revoke revokes the PEM encoded cert with the issuer obj is annotated with. A certificate
+// whose issuer has been deleted cannot be revoked any more and is skipped.
+func Revoke(ctx context.Context, issuers *issuer.Resolver, obj metav1.Object, cert []byte) error {
+	iss, err := issuers.ForObject(ctx, obj)
+	if errors.IsNotFound(err) {
+		log.Info("Issuer is gone, not revoking certificate", "Namespace", obj.GetNamespace(), "Name", obj.GetName())
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return iss.Provider.Deprovision(ctx, cert)
+}
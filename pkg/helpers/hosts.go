@@ -0,0 +1,41 @@
+package helpers
+
+import (
+	"sort"
+	"strings"
+
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SetHosts records the names a newly issued certificate covers in the annotations of obj
+func SetHosts(config certconf.Config, obj metav1.Object, hosts []string) {
+	annotations := obj.GetAnnotations()
+	annotations[config.General.Annotations.Hosts] = strings.Join(normalizeHosts(hosts), ",")
+	obj.SetAnnotations(annotations)
+}
+
+// HostsChanged reports whether hosts differ from the names recorded for the certificate of obj.
+// Certificates issued before the names were recorded are assumed to still match.
+func HostsChanged(config certconf.Config, obj metav1.Object, hosts []string) bool {
+	recorded, ok := obj.GetAnnotations()[config.General.Annotations.Hosts]
+	if !ok {
+		return false
+	}
+	return recorded != strings.Join(normalizeHosts(hosts), ",")
+}
+
+// normalizeHosts returns hosts in lower case, sorted and without duplicates or empty names
+func normalizeHosts(hosts []string) []string {
+	seen := map[string]bool{}
+	var normalized []string
+	for _, host := range hosts {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if len(host) > 0 && !seen[host] {
+			seen[host] = true
+			normalized = append(normalized, host)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized
+}
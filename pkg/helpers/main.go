@@ -25,19 +25,29 @@ func Apply(c client.Client, object runtime.Object) error {
 	return nil
 }
 
-func GetCert(host string, provider certs.Provider, ssl string) (certs.KeyPair, error) {
-	oneYear, timeErr := time.ParseDuration("8760h")
-	if timeErr != nil {
-		return certs.KeyPair{}, timeErr
+// GetCert retrieves a certificate from the provider, valid for one year unless the
+// request specifies its own duration
+func GetCert(ctx context.Context, req certs.CertificateRequest, provider certs.Provider) (certs.KeyPair, error) {
+	req, err := withDefaultDuration(req)
+	if err != nil {
+		return certs.KeyPair{}, err
 	}
 
 	// Retreive cert from provider
-	keyPair, err := provider.Provision(
-		host,
-		time.Now().Format(TimeFormat),
-		oneYear, false, 2048, "", ssl)
+	keyPair, err := provider.Provision(ctx, req)
 	if err != nil {
 		return certs.KeyPair{}, err
 	}
 	return keyPair, nil
 }
+
+func withDefaultDuration(req certs.CertificateRequest) (certs.CertificateRequest, error) {
+	if req.Duration == 0 {
+		oneYear, timeErr := time.ParseDuration("8760h")
+		if timeErr != nil {
+			return req, timeErr
+		}
+		req.Duration = oneYear
+	}
+	return req, nil
+}
@@ -0,0 +1,44 @@
+package helpers
+
+import (
+	"context"
+	"time"
+
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	"github.com/redhat-cop/cert-operator/pkg/notifier"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NotifyIssued tells the notifiers that a certificate valid until expiry was issued for obj
+func NotifyIssued(ctx context.Context, notifications *notifier.Dispatcher, kind string, obj metav1.Object, hosts []string, renewing bool, expiry time.Time) {
+	eventType := notifier.EventIssued
+	if renewing {
+		eventType = notifier.EventRenewed
+	}
+	notifications.Notify(ctx, notifier.Event{
+		Type:      eventType,
+		Kind:      kind,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Hosts:     hosts,
+		Expiry:    expiry,
+	})
+}
+
+// NotifyFailed tells the notifiers that no certificate could be issued for obj. When the
+// certificate obj holds, valid until expiry, is close to expiring that is what they are warned of.
+func NotifyFailed(ctx context.Context, notifications *notifier.Dispatcher, config certconf.Config, kind string, obj metav1.Object, hosts []string, expiry time.Time, err error) {
+	eventType := notifier.EventFailed
+	if !expiry.IsZero() && time.Until(expiry) < config.General.ExpiryWarningDuration() {
+		eventType = notifier.EventExpiring
+	}
+	notifications.Notify(ctx, notifier.Event{
+		Type:      eventType,
+		Kind:      kind,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Hosts:     hosts,
+		Expiry:    expiry,
+		Message:   err.Error(),
+	})
+}
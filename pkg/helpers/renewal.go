@@ -0,0 +1,49 @@
+package helpers
+
+import (
+	"time"
+
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// NextRenewal returns when the certificate recorded in the annotations of obj is due for
+// renewal. Certificates issued before the issued annotation existed are assumed to have
+// been valid for the configured duration.
+func NextRenewal(config certconf.Config, obj metav1.Object) (time.Time, error) {
+	annotations := obj.GetAnnotations()
+
+	expiry, err := Expiry(config, obj)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	issued, err := time.Parse(TimeFormat, annotations[config.General.Annotations.Issued])
+	if err != nil {
+		issued = expiry.Add(-config.General.CertDuration())
+	}
+
+	return config.General.Renewal.RenewAt(issued, expiry), nil
+}
+
+// RequeueAfter returns how long to wait until renewAt, but no less than
+// certconf.MinRenewalDelay, so a renewal that is overdue right after issuance does not loop
+func RequeueAfter(renewAt time.Time) time.Duration {
+	if wait := time.Until(renewAt); wait > certconf.MinRenewalDelay {
+		return wait
+	}
+	return certconf.MinRenewalDelay
+}
+
+// Expiry returns the expiry of the certificate recorded in the annotations of obj
+func Expiry(config certconf.Config, obj metav1.Object) (time.Time, error) {
+	return time.Parse(TimeFormat, obj.GetAnnotations()[config.General.Annotations.Expiry])
+}
+
+// SetIssued records the validity of a newly issued certificate in the annotations of obj
+func SetIssued(config certconf.Config, obj metav1.Object, expiry time.Time) {
+	annotations := obj.GetAnnotations()
+	annotations[config.General.Annotations.Issued] = time.Now().UTC().Format(TimeFormat)
+	annotations[config.General.Annotations.Expiry] = expiry.UTC().Format(TimeFormat)
+	obj.SetAnnotations(annotations)
+}
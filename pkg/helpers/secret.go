@@ -0,0 +1,118 @@
+package helpers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/redhat-cop/cert-operator/pkg/certs"
+	"github.com/redhat-cop/cert-operator/pkg/rand"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// SecretData returns the content and type of a Secret holding keyPair, either as PEM or,
+// when pkcs12 is set, as a PKCS12 bundle protected by a generated password
+func SecretData(keyPair certs.KeyPair, pkcs12 bool) (map[string][]byte, corev1.SecretType, error) {
+	dm := make(map[string][]byte)
+
+	if pkcs12 {
+		password := rand.String(24)
+		pemCrt, _ := pem.Decode(keyPair.Cert)
+		pemKey, _ := pem.Decode(keyPair.Key)
+		if pemCrt == nil || pemKey == nil {
+			return nil, "", certs.NewCertError("certificate or key is not PEM encoded")
+		}
+		p12cert, err := certs.ConvertToPKCS12(pemKey.Bytes, pemCrt.Bytes, certs.ChainToDER(keyPair.CA), password)
+		if err != nil {
+			return nil, "", err
+		}
+
+		dm["tls.p12"] = p12cert
+		dm["tls-p12-secret.txt"] = []byte(password)
+		// kept so the certificate can be revoked later
+		dm["tls.crt"] = keyPair.Cert
+		// kept so routes to the service can trust it
+		if len(keyPair.CA) > 0 {
+			dm["ca.crt"] = keyPair.CA
+		}
+
+		// not a tls secret since it holds no PEM certificate
+		return dm, corev1.SecretTypeOpaque, nil
+	}
+
+	dm["tls.crt"] = keyPair.Cert
+	dm["tls.key"] = keyPair.Key
+	if len(keyPair.CA) > 0 {
+		dm["ca.crt"] = keyPair.CA
+	}
+	return dm, corev1.SecretTypeTLS, nil
+}
+
+// ServiceSecretName returns the name of the Secret the certificate of a service is written to
+func ServiceSecretName(service string) string {
+	return service + "-certificate"
+}
+
+// SecretCA returns the PEM encoded CA that issued the certificate in a Secret SecretData wrote.
+// A self-signed certificate is its own CA.
+func SecretCA(secret *corev1.Secret) []byte {
+	if len(secret.Data["ca.crt"]) > 0 {
+		return secret.Data["ca.crt"]
+	}
+	cert, err := certs.ParseCertificate(secret.Data["tls.crt"])
+	if err == nil && cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil {
+		return secret.Data["tls.crt"]
+	}
+	return nil
+}
+
+// SecretCert returns the certificate held by a Secret SecretData wrote, or nil when the Secret
+// lacks any of the entries it should have, such as after it has been tampered with
+func SecretCert(secret *corev1.Secret, pkcs12 bool) *x509.Certificate {
+	required := []string{"tls.crt", "tls.key"}
+	secretType := corev1.SecretTypeTLS
+	if pkcs12 {
+		required = []string{"tls.crt", "tls.p12", "tls-p12-secret.txt"}
+		secretType = corev1.SecretTypeOpaque
+	}
+
+	if secret.Type != secretType {
+		return nil
+	}
+	for _, key := range required {
+		if len(secret.Data[key]) == 0 {
+			return nil
+		}
+	}
+
+	cert, err := certs.ParseCertificate(secret.Data["tls.crt"])
+	if err != nil {
+		return nil
+	}
+	return cert
+}
+
+// SourceKeyPair returns the key pair held by a kubernetes.io/tls Secret supplied by a user,
+// checking that the certificate and key belong together
+func SourceKeyPair(secret *corev1.Secret) (certs.KeyPair, error) {
+	if secret.Type != corev1.SecretTypeTLS {
+		return certs.KeyPair{}, certs.NewCertError("secret " + secret.Name + " is not of type " + string(corev1.SecretTypeTLS))
+	}
+	if len(secret.Data["tls.crt"]) == 0 || len(secret.Data["tls.key"]) == 0 {
+		return certs.KeyPair{}, certs.NewCertError("secret " + secret.Name + " lacks tls.crt or tls.key")
+	}
+	if _, err := tls.X509KeyPair(secret.Data["tls.crt"], secret.Data["tls.key"]); err != nil {
+		return certs.KeyPair{}, certs.NewCertError("secret " + secret.Name + " holds no valid key pair: " + err.Error())
+	}
+
+	cert, err := certs.ParseCertificate(secret.Data["tls.crt"])
+	if err != nil {
+		return certs.KeyPair{}, err
+	}
+	return certs.KeyPair{
+		Cert:   secret.Data["tls.crt"],
+		Key:    secret.Data["tls.key"],
+		CA:     secret.Data["ca.crt"],
+		Expiry: cert.NotAfter,
+	}, nil
+}
@@ -0,0 +1,310 @@
+package helpers
+
+import (
+	"context"
+	"time"
+
+	"github.com/redhat-cop/cert-operator/pkg/certs"
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	"github.com/redhat-cop/cert-operator/pkg/issuer"
+	"github.com/redhat-cop/cert-operator/pkg/metrics"
+	"github.com/redhat-cop/cert-operator/pkg/notifier"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// TLSSecret is a Secret an object serves a certificate from, and the names the certificate
+// has to cover
+type TLSSecret struct {
+	Name  string
+	Hosts []string
+}
+
+// TLSObject is an object that serves certificates from the Secrets it references, such as an
+// Ingress or a Gateway
+type TLSObject interface {
+	metav1.Object
+	runtime.Object
+}
+
+// TLSReconciler issues and renews the certificates of TLSObjects. Every Secret gets a
+// certificate of its own, covering the hosts served from it. The validity and names of that
+// certificate are recorded in the annotations of the Secret, so each is renewed on its own
+// schedule, while the annotations of the object hold its status and the earliest expiry.
+type TLSReconciler struct {
+	Client  client.Client
+	Scheme  *runtime.Scheme
+	Config  certconf.Config
+	Issuers *issuer.Resolver
+	// Ctx is cancelled when the manager stops, abandoning in-flight provider calls
+	Ctx           context.Context
+	Recorder      record.EventRecorder
+	Notifications *notifier.Dispatcher
+	// Kind is the kind of the objects, used in metrics and notifications
+	Kind string
+}
+
+// Reconcile issues a certificate into each of secrets of obj that lacks a current one.
+// specErr is a mistake in the spec of obj that keeps it from being secured, such as a Secret
+// without hosts; obj is marked failed until it is fixed and the status annotation is reset.
+func (r *TLSReconciler) Reconcile(obj TLSObject, secrets []TLSSecret, specErr error) (reconcile.Result, error) {
+	reqLogger := log.WithValues("Kind", r.Kind, "Request.Namespace", obj.GetNamespace(), "Request.Name", obj.GetName())
+
+	annotations := obj.GetAnnotations()
+	status := annotations[r.Config.General.Annotations.Status]
+	if expiry, err := Expiry(r.Config, obj); err == nil {
+		metrics.SetExpiry(r.Kind, obj.GetNamespace(), obj.GetName(), expiry)
+	}
+	if status != r.Config.General.Annotations.NeedCertValue && status != StatusPending && status != "secured" {
+		return reconcile.Result{}, nil
+	}
+
+	if specErr != nil {
+		var hosts []string
+		for _, s := range secrets {
+			hosts = append(hosts, s.Hosts...)
+		}
+		// nothing to retry until the object is fixed
+		r.Recorder.Event(obj, corev1.EventTypeWarning, EventIssueFailed, specErr.Error())
+		NotifyFailed(r.Ctx, r.Notifications, r.Config, r.Kind, obj, hosts, time.Time{}, specErr)
+		annotations[r.Config.General.Annotations.Status] = "failed"
+		annotations[r.Config.General.Annotations.StatusReason] = specErr.Error()
+		obj.SetAnnotations(annotations)
+		return reconcile.Result{}, Apply(r.Client, obj)
+	}
+
+	pkcs12 := annotations[r.Config.General.Annotations.Format] == r.Config.General.Annotations.Pkcs12Format
+
+	// expiry is the earliest expiry of the certificates being served, renewAt the earliest
+	// renewal of those that are kept
+	var expiry, renewAt time.Time
+	var due []TLSSecret
+	current := map[string]time.Time{}
+	for _, s := range secrets {
+		reason, certExpiry, certRenewAt, err := r.check(obj, s, pkcs12)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if len(reason) == 0 && status != r.Config.General.Annotations.NeedCertValue {
+			expiry = earliest(expiry, certExpiry)
+			renewAt = earliest(renewAt, certRenewAt)
+			continue
+		}
+		if len(reason) > 0 {
+			reqLogger.Info(reason+", issuing a new certificate", "Secret", s.Name)
+		}
+		current[s.Name] = certExpiry
+		due = append(due, s)
+	}
+	if len(due) == 0 && status == "secured" {
+		return reconcile.Result{RequeueAfter: RequeueAfter(renewAt)}, nil
+	}
+	reqLogger.Info("Reconciling " + r.Kind)
+
+	failure := EventIssueFailed
+	iss, issErr := r.Issuers.ForObject(r.Ctx, obj)
+	if issErr != nil {
+		failure = EventProviderUnavailable
+	}
+
+	var failErr error
+	var failedHosts []string
+	// a failed renewal leaves the current certificate in place, a failed first issue fails obj
+	renewalFailed := true
+	pending := false
+	issuedAny := false
+	for _, s := range due {
+		renewing := status != r.Config.General.Annotations.NeedCertValue && !current[s.Name].IsZero()
+
+		issued, certExpiry, err := false, time.Time{}, issErr
+		if err == nil {
+			issued, certExpiry, err = r.issue(obj, s, iss, pkcs12, renewing)
+		}
+		if r.Ctx.Err() != nil {
+			// shutting down, leave the status alone so the next leader picks it up
+			return reconcile.Result{}, r.Ctx.Err()
+		}
+		if !issued {
+			// the current certificate stays in place
+			certExpiry = current[s.Name]
+		}
+		if err != nil {
+			if failErr == nil {
+				failErr = err
+			}
+			failedHosts = append(failedHosts, s.Hosts...)
+			renewalFailed = renewalFailed && renewing
+		} else if !issued {
+			pending = true
+		}
+		issuedAny = issuedAny || issued
+		expiry = earliest(expiry, certExpiry)
+	}
+
+	if issuedAny {
+		SetIssued(r.Config, obj, expiry)
+		AddFinalizer(obj, Finalizer)
+	}
+
+	annotations = obj.GetAnnotations()
+	switch {
+	case failErr != nil:
+		r.Recorder.Event(obj, corev1.EventTypeWarning, failure, failErr.Error())
+		NotifyFailed(r.Ctx, r.Notifications, r.Config, r.Kind, obj, failedHosts, expiry, failErr)
+		if renewalFailed {
+			// keep serving the current certificates and retry with backoff
+			annotations[r.Config.General.Annotations.Status] = "secured"
+			annotations[r.Config.General.Annotations.StatusReason] = "Renewal failed: " + failErr.Error()
+		} else {
+			annotations[r.Config.General.Annotations.Status] = "failed"
+			annotations[r.Config.General.Annotations.StatusReason] = failErr.Error()
+		}
+	case pending:
+		// the CA has not issued every certificate yet, collect them on a later reconcile
+		annotations[r.Config.General.Annotations.Status] = StatusPending
+	default:
+		annotations[r.Config.General.Annotations.Status] = "secured"
+		delete(annotations, r.Config.General.Annotations.StatusReason)
+	}
+	obj.SetAnnotations(annotations)
+
+	if err := Apply(r.Client, obj); err != nil {
+		reqLogger.Error(err, "Failed to apply "+r.Kind)
+		return reconcile.Result{}, err
+	}
+
+	switch {
+	case failErr != nil && renewalFailed:
+		return reconcile.Result{}, failErr
+	case failErr != nil:
+		return reconcile.Result{}, nil
+	case pending:
+		reqLogger.Info("Waiting for certificate to be issued")
+		return reconcile.Result{RequeueAfter: r.Config.General.PollDuration()}, nil
+	}
+	// the update brings obj back for the renewal check
+	return reconcile.Result{}, nil
+}
+
+// issue obtains a certificate for s from iss and writes it to the Secret of s. issued is false
+// while the CA has not issued it yet.
+func (r *TLSReconciler) issue(obj TLSObject, s TLSSecret, iss issuer.Issuer, pkcs12 bool, renewing bool) (issued bool, expiry time.Time, err error) {
+	certReq := certs.NewCertificateRequest(s.Hosts...)
+	certReq.Options[certs.OptionNamespace] = obj.GetNamespace()
+	certReq.Duration = iss.CertDuration(r.Config.General.CertDuration())
+
+	var keyPair certs.KeyPair
+	keyPair, issued, err = ObtainPendingCert(r.Ctx, r.Client, r.Scheme, obj, certReq, iss.Provider, s.Name+"-pending-key")
+	metrics.ObserveIssue(iss.Kind, obj.GetNamespace(), issued, err)
+	if err != nil || !issued {
+		return false, time.Time{}, err
+	}
+
+	dm, secretType, err := SecretData(keyPair, pkcs12)
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	secret := &corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Secret",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        s.Name,
+			Namespace:   obj.GetNamespace(),
+			Annotations: map[string]string{},
+		},
+		Data: dm,
+		Type: secretType,
+	}
+	SetIssued(r.Config, secret, keyPair.Expiry)
+	SetHosts(r.Config, secret, s.Hosts)
+	// owned by obj, so it is garbage collected with it and changes to it are noticed
+	if err := controllerutil.SetControllerReference(obj, secret, r.Scheme); err != nil {
+		return false, time.Time{}, err
+	}
+	if err := Apply(r.Client, secret); err != nil {
+		return false, time.Time{}, err
+	}
+
+	r.Recorder.Eventf(obj, corev1.EventTypeNormal, IssuedReason(renewing), "Certificate for secret %s issued, valid until %s", s.Name, keyPair.Expiry.UTC().Format(TimeFormat))
+	NotifyIssued(r.Ctx, r.Notifications, r.Kind, obj, s.Hosts, renewing, keyPair.Expiry)
+	return true, keyPair.Expiry, nil
+}
+
+// check returns why the certificate in the Secret of s has to be issued again, or an empty
+// reason and when it is due for renewal when it is kept. expiry is that of the certificate
+// the Secret holds, zero when it holds none.
+func (r *TLSReconciler) check(obj TLSObject, s TLSSecret, pkcs12 bool) (reason string, expiry time.Time, renewAt time.Time, err error) {
+	secret := &corev1.Secret{}
+	err = r.Client.Get(context.TODO(), types.NamespacedName{Namespace: obj.GetNamespace(), Name: s.Name}, secret)
+	if errors.IsNotFound(err) {
+		return "Certificate secret is missing", time.Time{}, time.Time{}, nil
+	}
+	if err != nil {
+		return "", time.Time{}, time.Time{}, err
+	}
+
+	cert := SecretCert(secret, pkcs12)
+	if cert == nil || cert.NotAfter.UTC().Format(TimeFormat) != secret.Annotations[r.Config.General.Annotations.Expiry] {
+		return "Certificate secret was modified", time.Time{}, time.Time{}, nil
+	}
+	expiry = cert.NotAfter
+	if HostsChanged(r.Config, secret, s.Hosts) {
+		return "Hosts changed", expiry, time.Time{}, nil
+	}
+
+	renewAt, err = NextRenewal(r.Config, secret)
+	if err != nil || time.Until(renewAt) <= 0 {
+		return "Certificate is due for renewal", expiry, time.Time{}, nil
+	}
+	return "", expiry, renewAt, nil
+}
+
+// Revoke revokes the certificates the operator issued into secrets of obj, which is being
+// deleted, and releases obj
+func (r *TLSReconciler) Revoke(obj TLSObject, secrets []TLSSecret) error {
+	if !HasFinalizer(obj, Finalizer) {
+		return nil
+	}
+	reqLogger := log.WithValues("Kind", r.Kind, "Request.Namespace", obj.GetNamespace(), "Request.Name", obj.GetName())
+
+	for _, s := range secrets {
+		secret := &corev1.Secret{}
+		err := r.Client.Get(context.TODO(), types.NamespacedName{Namespace: obj.GetNamespace(), Name: s.Name}, secret)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		// a Secret obj does not own holds a certificate the operator did not issue for it
+		if !metav1.IsControlledBy(secret, obj) || len(secret.Data["tls.crt"]) == 0 {
+			continue
+		}
+
+		if err := Revoke(r.Ctx, r.Issuers, obj, secret.Data["tls.crt"]); err != nil {
+			reqLogger.Error(err, "Failed to revoke certificate", "Secret", s.Name)
+			return err
+		}
+		reqLogger.Info("Revoked certificate of deleted "+r.Kind, "Secret", s.Name)
+	}
+
+	RemoveFinalizer(obj, Finalizer)
+	return r.Client.Update(context.TODO(), obj)
+}
+
+// earliest returns the earlier of a and b, ignoring zero times
+func earliest(a time.Time, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
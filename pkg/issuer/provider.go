@@ -0,0 +1,64 @@
+package issuer
+
+import (
+	"github.com/redhat-cop/cert-operator/pkg/certs"
+	"github.com/redhat-cop/cert-operator/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// NewProvider builds the provider described by config, with every call bounded by its timeout
+// and its latency recorded
+func NewProvider(mgr manager.Manager, config certs.ProviderConfig) (certs.Provider, error) {
+	var provider certs.Provider
+
+	switch config.Kind {
+	case "none":
+		log.Info("None provider.")
+		provider = new(certs.NoneProvider)
+	case "self-signed":
+		log.Info("Self Signed provider.")
+		provider = new(certs.SelfSignedProvider)
+	case "venafi":
+		log.Info("Venafi Cert provider.")
+		venafiProvider, err := certs.NewVenafiProvider(config.Venafi)
+		if err != nil {
+			return nil, certs.NewCertError("There was a problem configuring the Venafi provider. \n" +
+				"\t" + err.Error())
+		}
+		provider = venafiProvider
+	case "ca":
+		log.Info("Internal CA provider.")
+		caProvider, err := certs.NewCAProvider(mgr.GetConfig(), config.CA)
+		if err != nil {
+			return nil, certs.NewCertError("There was a problem configuring the internal CA provider. \n" +
+				"\t" + err.Error())
+		}
+		provider = caProvider
+	case "acme":
+		log.Info("ACME provider.")
+		acmeProvider, err := certs.NewACMEProvider(mgr.GetConfig(), mgr.GetScheme(), config.ACME)
+		if err != nil {
+			return nil, certs.NewCertError("There was a problem configuring the ACME provider. \n" +
+				"\t" + err.Error())
+		}
+		provider = acmeProvider
+	case "vault":
+		log.Info("Vault PKI provider.")
+		vaultProvider, err := certs.NewVaultProvider(config.Vault)
+		if err != nil {
+			return nil, certs.NewCertError("There was a problem configuring the Vault provider. \n" +
+				"\t" + err.Error())
+		}
+		provider = vaultProvider
+	default:
+		return nil, certs.NewCertError("There was a problem detecting which provider to configure. \n" +
+			"\tProvider kind `" + config.Kind + "` is invalid.")
+	}
+
+	timeout, err := config.CallTimeout()
+	if err != nil {
+		return nil, certs.NewCertError("There was a problem configuring the provider timeout. \n" +
+			"\t" + err.Error())
+	}
+	return metrics.Instrument(certs.WithTimeout(provider, timeout), config.Kind), nil
+}
@@ -0,0 +1,295 @@
+package issuer
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	certsv1alpha1 "github.com/redhat-cop/cert-operator/pkg/apis/certs/v1alpha1"
+	"github.com/redhat-cop/cert-operator/pkg/certs"
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+var log = logf.Log.WithName("issuer")
+
+// Issuer is a provider resolved for an object together with the defaults that apply to it
+type Issuer struct {
+	Provider certs.Provider
+	// Kind is the kind of provider, e.g. `acme`
+	Kind string
+	// Duration is the validity of certificates that do not request their own, zero if unset
+	Duration time.Duration
+}
+
+// CertDuration returns the validity to request, the issuer's default or else fallback
+func (i Issuer) CertDuration(fallback time.Duration) time.Duration {
+	if i.Duration > 0 {
+		return i.Duration
+	}
+	return fallback
+}
+
+// Resolver finds the provider that issues the certificate of an object. That is the Issuer or
+// ClusterIssuer the object references, or otherwise the provider the operator is configured with.
+// Providers built for issuers are kept until the issuer or its credentials change.
+type Resolver struct {
+	mgr    manager.Manager
+	client client.Client
+	config certconf.Config
+
+	defaultIssuer Issuer
+
+	mutex  sync.Mutex
+	issued map[string]cachedIssuer
+}
+
+type cachedIssuer struct {
+	version string
+	issuer  Issuer
+}
+
+func NewResolver(mgr manager.Manager, config certconf.Config) (*Resolver, error) {
+	providerConfig := config.Provider
+	providerConfig.Venafi = providerConfig.Venafi.WithEnvDefaults()
+	provider, err := NewProvider(mgr, providerConfig)
+	if err != nil {
+		return nil, certs.NewCertError(err.Error() + " \n" + config.String())
+	}
+
+	// read issuers directly, they may live outside the namespace the manager caches
+	c, err := client.New(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme()})
+	if err != nil {
+		return nil, certs.NewCertError("could not create kubernetes client: " + err.Error())
+	}
+
+	return &Resolver{
+		mgr:    mgr,
+		client: c,
+		config: config,
+		defaultIssuer: Issuer{
+			Provider: provider,
+			Kind:     config.Provider.Kind,
+		},
+		issued: map[string]cachedIssuer{},
+	}, nil
+}
+
+// NewStaticResolver returns a Resolver that resolves objects without an issuer to defaultIssuer
+// and reads Issuers and ClusterIssuers with c, for use without a manager such as in tests.
+// Issuers of the ca and acme kinds need the manager and cannot be resolved by it.
+func NewStaticResolver(c client.Client, config certconf.Config, defaultIssuer Issuer) *Resolver {
+	return &Resolver{
+		client:        c,
+		config:        config,
+		defaultIssuer: defaultIssuer,
+		issued:        map[string]cachedIssuer{},
+	}
+}
+
+// ForObject resolves the issuer named by the issuer or cluster-issuer annotation of obj
+func (r *Resolver) ForObject(ctx context.Context, obj metav1.Object) (Issuer, error) {
+	annotations := obj.GetAnnotations()
+	if name := annotations[r.config.General.Annotations.Issuer]; len(name) > 0 {
+		return r.Resolve(ctx, obj.GetNamespace(), &certsv1alpha1.IssuerReference{Name: name, Kind: certsv1alpha1.IssuerKind})
+	}
+	if name := annotations[r.config.General.Annotations.ClusterIssuer]; len(name) > 0 {
+		return r.Resolve(ctx, obj.GetNamespace(), &certsv1alpha1.IssuerReference{Name: name, Kind: certsv1alpha1.ClusterIssuerKind})
+	}
+	return r.defaultIssuer, nil
+}
+
+// Resolve returns the issuer ref points to for an object in namespace, or the operator's
+// provider when ref is nil
+func (r *Resolver) Resolve(ctx context.Context, namespace string, ref *certsv1alpha1.IssuerReference) (Issuer, error) {
+	if ref == nil {
+		return r.defaultIssuer, nil
+	}
+
+	var obj metav1.Object
+	var spec certsv1alpha1.IssuerSpec
+	var secretNamespace string
+
+	switch ref.Kind {
+	case "", certsv1alpha1.IssuerKind:
+		issuer := &certsv1alpha1.Issuer{}
+		if err := r.client.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, issuer); err != nil {
+			return Issuer{}, err
+		}
+		obj, spec, secretNamespace = issuer, issuer.Spec, namespace
+	case certsv1alpha1.ClusterIssuerKind:
+		issuer := &certsv1alpha1.ClusterIssuer{}
+		if err := r.client.Get(ctx, types.NamespacedName{Name: ref.Name}, issuer); err != nil {
+			return Issuer{}, err
+		}
+		operatorNamespace, err := certs.OperatorNamespace("")
+		if err != nil {
+			return Issuer{}, err
+		}
+		obj, spec, secretNamespace = issuer, issuer.Spec, operatorNamespace
+	default:
+		return Issuer{}, certs.NewCertError("Unrecognized issuer kind: " + ref.Kind)
+	}
+
+	version := string(obj.GetUID()) + "/" + obj.GetResourceVersion()
+	var credentials map[string][]byte
+	if spec.CredentialsSecretRef != nil {
+		secret := &corev1.Secret{}
+		err := r.client.Get(ctx, types.NamespacedName{Namespace: secretNamespace, Name: spec.CredentialsSecretRef.Name}, secret)
+		if err != nil {
+			return Issuer{}, err
+		}
+		credentials = secret.Data
+		version += "/" + secret.ResourceVersion
+	}
+
+	key := ref.Kind + "/" + obj.GetNamespace() + "/" + obj.GetName()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if cached, ok := r.issued[key]; ok && cached.version == version {
+		return cached.issuer, nil
+	}
+
+	providerConfig, err := r.providerConfig(obj, secretNamespace, spec, credentials)
+	if err != nil {
+		return Issuer{}, err
+	}
+	provider, err := NewProvider(r.mgr, providerConfig)
+	if err != nil {
+		return Issuer{}, err
+	}
+
+	issuer := Issuer{
+		Provider: provider,
+		Kind:     spec.Provider,
+	}
+	if spec.Duration != nil {
+		issuer.Duration = spec.Duration.Duration
+	}
+	r.issued[key] = cachedIssuer{version: version, issuer: issuer}
+
+	return issuer, nil
+}
+
+// providerConfig translates an issuer spec into the configuration of its provider. Settings
+// the spec has no place for, such as the ACME solver image, are taken from the operator config.
+func (r *Resolver) providerConfig(obj metav1.Object, namespace string, spec certsv1alpha1.IssuerSpec, credentials map[string][]byte) (certs.ProviderConfig, error) {
+	config := certs.ProviderConfig{
+		Kind: spec.Provider,
+	}
+	if spec.Timeout != nil {
+		config.Timeout = spec.Timeout.Duration.String()
+	}
+
+	// issuers of the same name in different namespaces must not share state
+	name := obj.GetName()
+	if len(obj.GetNamespace()) > 0 {
+		name = obj.GetNamespace() + "-" + name
+	}
+
+	switch spec.Provider {
+	case "ca":
+		config.CA = r.config.Provider.CA
+		config.CA.SecretName = obj.GetName() + "-ca"
+		config.CA.SecretNamespace = namespace
+		if spec.CA != nil {
+			if len(spec.CA.SecretName) > 0 {
+				config.CA.SecretName = spec.CA.SecretName
+			}
+			if len(spec.CA.CommonName) > 0 {
+				config.CA.CommonName = spec.CA.CommonName
+			}
+			if len(spec.CA.Organization) > 0 {
+				config.CA.Organization = spec.CA.Organization
+			}
+			if spec.CA.Validity != nil {
+				config.CA.Validity = spec.CA.Validity.Duration.String()
+			}
+		}
+	case "acme":
+		if spec.ACME == nil {
+			return config, certs.NewCertError("issuer " + obj.GetName() + " has no acme settings")
+		}
+		config.ACME = r.config.Provider.ACME
+		config.ACME.DirectoryURL = spec.ACME.Server
+		// the CA bundle of the operator's directory has no bearing on the server of the issuer
+		config.ACME.CAPath = ""
+		config.ACME.InsecureSkipVerify = spec.InsecureSkipVerify
+		config.ACME.Email = spec.ACME.Email
+		config.ACME.AccountSecret = name + "-acme-account"
+		if len(spec.ACME.SolverNamespace) > 0 {
+			config.ACME.SolverNamespace = spec.ACME.SolverNamespace
+		}
+	case "vault":
+		if spec.Vault == nil {
+			return config, certs.NewCertError("issuer " + obj.GetName() + " has no vault settings")
+		}
+		config.Vault = certs.VaultConfig{
+			Address:            spec.Vault.Server,
+			Mount:              valueOrDefault(spec.Vault.Mount, "pki"),
+			Role:               spec.Vault.Role,
+			Mode:               valueOrDefault(spec.Vault.Mode, "issue"),
+			InsecureSkipVerify: spec.InsecureSkipVerify,
+			Auth: certs.VaultAuthConfig{
+				Method:   valueOrDefault(spec.Vault.AuthMethod, "token"),
+				Path:     spec.Vault.AuthPath,
+				Role:     spec.Vault.AuthRole,
+				Token:    credential(credentials, "token"),
+				JWT:      credential(credentials, "jwt"),
+				RoleID:   credential(credentials, "role-id"),
+				SecretID: credential(credentials, "secret-id"),
+			},
+		}
+		// the server is chosen by whoever creates the issuer, so only credentials they supply
+		// may be sent to it. The operator's own service account token is lent to ClusterIssuers
+		// alone, and a missing token must not fall back to VAULT_TOKEN.
+		switch config.Vault.Auth.Method {
+		case "token":
+			if len(config.Vault.Auth.Token) == 0 {
+				return config, certs.NewCertError("issuer " + obj.GetName() + " has no token in its credentials secret")
+			}
+		case "kubernetes":
+			if len(config.Vault.Auth.JWT) == 0 {
+				if len(obj.GetNamespace()) > 0 {
+					return config, certs.NewCertError("issuer " + obj.GetName() + " has no jwt in its credentials secret")
+				}
+				config.Vault.Auth.TokenPath = r.config.Provider.Vault.Auth.TokenPath
+			}
+		}
+	case "venafi":
+		if spec.Venafi == nil {
+			return config, certs.NewCertError("issuer " + obj.GetName() + " has no venafi settings")
+		}
+		config.Venafi = certs.VenafiConfig{
+			Connector: valueOrDefault(spec.Venafi.Connector, "tpp"),
+			URL:       spec.Venafi.URL,
+			Zone:      spec.Venafi.Zone,
+			User:      credential(credentials, "user"),
+			Password:  credential(credentials, "password"),
+			APIKey:    credential(credentials, "api-key"),
+			// the TLS settings of an issuer reach its own provider only
+			InsecureSkipVerify: spec.InsecureSkipVerify,
+		}
+	}
+
+	return config, nil
+}
+
+func credential(credentials map[string][]byte, key string) string {
+	return strings.TrimSpace(string(credentials[key]))
+}
+
+func valueOrDefault(value string, fallback string) string {
+	if len(value) > 0 {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,108 @@
+package issuer
+
+import (
+	"testing"
+
+	certsv1alpha1 "github.com/redhat-cop/cert-operator/pkg/apis/certs/v1alpha1"
+	certconf "github.com/redhat-cop/cert-operator/pkg/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestProviderConfigVaultAuth(t *testing.T) {
+	config := certconf.DefaultConfig()
+	config.Provider.Vault.Auth.TokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	r := NewStaticResolver(nil, config, Issuer{})
+
+	issuer := &certsv1alpha1.Issuer{ObjectMeta: metav1.ObjectMeta{Name: "team-vault", Namespace: "team"}}
+	clusterIssuer := &certsv1alpha1.ClusterIssuer{ObjectMeta: metav1.ObjectMeta{Name: "vault"}}
+
+	tests := []struct {
+		name        string
+		obj         metav1.Object
+		method      string
+		credentials map[string][]byte
+		valid       bool
+		tokenPath   string
+	}{
+		{"issuer token", issuer, "token", map[string][]byte{"token": []byte("s.team")}, true, ""},
+		{"issuer without token", issuer, "token", nil, false, ""},
+		{"issuer kubernetes", issuer, "kubernetes", map[string][]byte{"jwt": []byte("team-jwt")}, true, ""},
+		{"issuer kubernetes without jwt", issuer, "kubernetes", nil, false, ""},
+		{"cluster issuer kubernetes", clusterIssuer, "kubernetes", nil, true, config.Provider.Vault.Auth.TokenPath},
+		{"cluster issuer kubernetes with jwt", clusterIssuer, "kubernetes", map[string][]byte{"jwt": []byte("jwt")}, true, ""},
+		{"issuer approle", issuer, "approle", map[string][]byte{"role-id": []byte("role"), "secret-id": []byte("secret")}, true, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// setup
+			spec := certsv1alpha1.IssuerSpec{
+				Provider: "vault",
+				Vault: &certsv1alpha1.VaultIssuer{
+					Server:     "https://vault.team.example.com:8200",
+					Role:       "web",
+					AuthMethod: test.method,
+				},
+			}
+
+			// act
+			providerConfig, err := r.providerConfig(test.obj, "team", spec, test.credentials)
+
+			// assert
+			if !test.valid {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if providerConfig.Vault.Auth.TokenPath != test.tokenPath {
+				t.Fatalf("expected token path %q, got %q", test.tokenPath, providerConfig.Vault.Auth.TokenPath)
+			}
+			if providerConfig.Vault.Auth.JWT != string(test.credentials["jwt"]) {
+				t.Fatal("jwt was not taken from the credentials")
+			}
+		})
+	}
+}
+
+func TestProviderConfigInsecureSkipVerify(t *testing.T) {
+	config := certconf.DefaultConfig()
+	config.Provider.ACME.CAPath = "/etc/pebble/ca.pem"
+	r := NewStaticResolver(nil, config, Issuer{})
+	issuer := &certsv1alpha1.Issuer{ObjectMeta: metav1.ObjectMeta{Name: "team", Namespace: "team"}}
+
+	for _, insecure := range []bool{false, true} {
+		// setup
+		venafi := certsv1alpha1.IssuerSpec{
+			Provider:           "venafi",
+			InsecureSkipVerify: insecure,
+			Venafi:             &certsv1alpha1.VenafiIssuer{Connector: "cloud", Zone: "certs"},
+		}
+		acme := certsv1alpha1.IssuerSpec{
+			Provider:           "acme",
+			InsecureSkipVerify: insecure,
+			ACME:               &certsv1alpha1.ACMEIssuer{Server: "https://acme.team.example.com/dir"},
+		}
+
+		// act
+		venafiConfig, err := r.providerConfig(issuer, "team", venafi, map[string][]byte{"api-key": []byte("key")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		acmeConfig, err := r.providerConfig(issuer, "team", acme, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// assert
+		if venafiConfig.Venafi.InsecureSkipVerify != insecure || acmeConfig.ACME.InsecureSkipVerify != insecure {
+			t.Fatalf("insecureSkipVerify %v was not passed to the provider", insecure)
+		}
+		if len(acmeConfig.ACME.CAPath) > 0 {
+			t.Fatal("CA bundle of the operator's directory was used for an issuer")
+		}
+	}
+}
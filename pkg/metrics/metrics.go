@@ -0,0 +1,98 @@
+// Package metrics holds the Prometheus metrics of the operator. They are registered with the
+// controller-runtime registry and served on the metrics port of the manager.
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	crmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "cert_operator"
+
+var (
+	issued = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "certificates_issued_total",
+		Help:      "Number of certificates issued, by provider and namespace of the secured object.",
+	}, []string{"provider", "namespace"})
+
+	failures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "certificate_failures_total",
+		Help:      "Number of failed attempts to issue a certificate, by provider and namespace of the secured object.",
+	}, []string{"provider", "namespace"})
+
+	providerLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "provider_request_duration_seconds",
+		Help:      "Duration of calls to certificate providers, by provider and operation.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 180, 300},
+	}, []string{"provider", "operation"})
+
+	expiry = &expiryCollector{
+		desc: prometheus.NewDesc(
+			namespace+"_certificate_expiry_seconds",
+			"Seconds until the certificate of a managed object expires.",
+			[]string{"kind", "namespace", "name"}, nil),
+		expiries: map[expiryKey]time.Time{},
+	}
+)
+
+func init() {
+	crmetrics.Registry.MustRegister(issued, failures, providerLatency, expiry)
+}
+
+// ObserveIssue counts the outcome of an attempt to get a certificate for an object in namespace.
+// Requests still waiting to be issued are not counted.
+func ObserveIssue(provider string, namespace string, certIssued bool, err error) {
+	if err != nil {
+		failures.WithLabelValues(provider, namespace).Inc()
+	} else if certIssued {
+		issued.WithLabelValues(provider, namespace).Inc()
+	}
+}
+
+// SetExpiry records when the certificate of the object of the given kind expires
+func SetExpiry(kind string, namespace string, name string, notAfter time.Time) {
+	expiry.mutex.Lock()
+	defer expiry.mutex.Unlock()
+	expiry.expiries[expiryKey{kind, namespace, name}] = notAfter
+}
+
+// DeleteExpiry forgets the certificate of an object that is gone
+func DeleteExpiry(kind string, namespace string, name string) {
+	expiry.mutex.Lock()
+	defer expiry.mutex.Unlock()
+	delete(expiry.expiries, expiryKey{kind, namespace, name})
+}
+
+type expiryKey struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+// expiryCollector reports the time left on each certificate as of the scrape, so the gauge
+// does not go stale between reconciles
+type expiryCollector struct {
+	desc *prometheus.Desc
+
+	mutex    sync.Mutex
+	expiries map[expiryKey]time.Time
+}
+
+func (c *expiryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *expiryCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, notAfter := range c.expiries {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, time.Until(notAfter).Seconds(),
+			key.kind, key.namespace, key.name)
+	}
+}
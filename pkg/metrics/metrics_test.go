@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// collectExpiries returns the seconds left reported for each object by the expiry collector
+func collectExpiries(t *testing.T) map[expiryKey]float64 {
+	ch := make(chan prometheus.Metric, 16)
+	go func() {
+		expiry.Collect(ch)
+		close(ch)
+	}()
+
+	values := map[expiryKey]float64{}
+	for metric := range ch {
+		m := &dto.Metric{}
+		if err := metric.Write(m); err != nil {
+			t.Fatal(err)
+		}
+		labels := map[string]string{}
+		for _, label := range m.GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		values[expiryKey{labels["kind"], labels["namespace"], labels["name"]}] = m.GetGauge().GetValue()
+	}
+	return values
+}
+
+func TestExpiryCollector(t *testing.T) {
+	// setup
+	key := expiryKey{"Route", "test", "example"}
+	defer DeleteExpiry(key.kind, key.namespace, key.name)
+
+	// act
+	SetExpiry(key.kind, key.namespace, key.name, time.Now().Add(time.Hour))
+	values := collectExpiries(t)
+
+	// assert
+	left, ok := values[key]
+	if !ok {
+		t.Fatal("expiry was not collected")
+	}
+	if left <= 3590 || left > 3600 {
+		t.Fatalf("expected about an hour left, got %f seconds", left)
+	}
+}
+
+func TestExpiryCollectorReplaces(t *testing.T) {
+	// setup
+	key := expiryKey{"Service", "test", "example"}
+	defer DeleteExpiry(key.kind, key.namespace, key.name)
+	SetExpiry(key.kind, key.namespace, key.name, time.Now().Add(time.Hour))
+
+	// act
+	SetExpiry(key.kind, key.namespace, key.name, time.Now().Add(-time.Minute))
+	values := collectExpiries(t)
+
+	// assert
+	if left := values[key]; left >= 0 {
+		t.Fatalf("expected an expired certificate to report negative seconds, got %f", left)
+	}
+}
+
+func TestDeleteExpiry(t *testing.T) {
+	// setup
+	key := expiryKey{"Ingress", "test", "example"}
+	other := expiryKey{"Ingress", "test", "other"}
+	defer DeleteExpiry(other.kind, other.namespace, other.name)
+	SetExpiry(key.kind, key.namespace, key.name, time.Now().Add(time.Hour))
+	SetExpiry(other.kind, other.namespace, other.name, time.Now().Add(time.Hour))
+
+	// act
+	DeleteExpiry(key.kind, key.namespace, key.name)
+	values := collectExpiries(t)
+
+	// assert
+	if _, ok := values[key]; ok {
+		t.Fatal("expiry of a deleted object is still collected")
+	}
+	if _, ok := values[other]; !ok {
+		t.Fatal("expiry of another object was deleted")
+	}
+}
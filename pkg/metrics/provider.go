@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/redhat-cop/cert-operator/pkg/certs"
+)
+
+type instrumentedProvider struct {
+	provider certs.Provider
+	kind     string
+}
+
+type instrumentedAsyncProvider struct {
+	instrumentedProvider
+	async certs.AsyncProvider
+}
+
+// Instrument records the latency of every call to provider, labelled with its kind.
+// The returned Provider is an AsyncProvider if provider is one.
+func Instrument(provider certs.Provider, kind string) certs.Provider {
+	p := instrumentedProvider{
+		provider: provider,
+		kind:     kind,
+	}
+	if async, ok := provider.(certs.AsyncProvider); ok {
+		return &instrumentedAsyncProvider{instrumentedProvider: p, async: async}
+	}
+	return &p
+}
+
+func (p *instrumentedProvider) observe(operation string, start time.Time) {
+	providerLatency.WithLabelValues(p.kind, operation).Observe(time.Since(start).Seconds())
+}
+
+func (p *instrumentedProvider) Provision(ctx context.Context, req certs.CertificateRequest) (certs.KeyPair, error) {
+	defer p.observe("provision", time.Now())
+	return p.provider.Provision(ctx, req)
+}
+
+func (p *instrumentedProvider) Deprovision(ctx context.Context, cert []byte) error {
+	defer p.observe("deprovision", time.Now())
+	return p.provider.Deprovision(ctx, cert)
+}
+
+func (p *instrumentedAsyncProvider) Submit(ctx context.Context, req certs.CertificateRequest) (certs.PendingRequest, error) {
+	defer p.observe("submit", time.Now())
+	return p.async.Submit(ctx, req)
+}
+
+func (p *instrumentedAsyncProvider) Retrieve(ctx context.Context, pending certs.PendingRequest) (certs.KeyPair, error) {
+	defer p.observe("retrieve", time.Now())
+	return p.async.Retrieve(ctx, pending)
+}
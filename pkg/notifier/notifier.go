@@ -0,0 +1,237 @@
+// Package notifier tells people about the certificates the operator manages, through chat,
+// webhooks or email.
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redhat-cop/cert-operator/pkg/certs"
+	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+)
+
+var log = logf.Log.WithName("notifier")
+
+type EventType string
+
+const (
+	EventIssued   EventType = "issued"
+	EventRenewed  EventType = "renewed"
+	EventFailed   EventType = "failed"
+	EventExpiring EventType = "expiring"
+
+	// sendTimeout bounds the delivery of an event to a single notifier
+	sendTimeout = 10 * time.Second
+	// repeatInterval is how long repeated failure and expiry warnings for an object are held back
+	repeatInterval = time.Hour
+	// queueSize is the number of events waiting to be sent beyond which new events are dropped
+	queueSize = 100
+)
+
+// Event describes something that happened to the certificate of an object
+type Event struct {
+	Type      EventType `json:"type"`
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	Hosts     []string  `json:"hosts,omitempty"`
+	Expiry    time.Time `json:"expiry,omitempty"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// Text returns a one line, human readable description of the event
+func (e Event) Text() string {
+	subject := fmt.Sprintf("%s %s/%s", e.Kind, e.Namespace, e.Name)
+	if len(e.Hosts) > 0 {
+		subject += " (" + strings.Join(e.Hosts, ", ") + ")"
+	}
+
+	switch e.Type {
+	case EventIssued:
+		return fmt.Sprintf("Certificate for %s was issued, valid until %s", subject, e.Expiry.UTC().Format(time.RFC3339))
+	case EventRenewed:
+		return fmt.Sprintf("Certificate for %s was renewed, valid until %s", subject, e.Expiry.UTC().Format(time.RFC3339))
+	case EventExpiring:
+		return fmt.Sprintf("Certificate for %s expires at %s and could not be renewed: %s", subject, e.Expiry.UTC().Format(time.RFC3339), e.Message)
+	default:
+		return fmt.Sprintf("Certificate for %s could not be issued: %s", subject, e.Message)
+	}
+}
+
+// Notifier delivers events to a single destination
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// Config describes a notifier. Kind is `slack`, `webhook` or `smtp`. IntegrationURL is the
+// incoming webhook of Slack or the URL events are posted to as JSON. Events limits the
+// notifier to the given event types, all events are sent when it is empty.
+type Config struct {
+	Name           string            `json:"name"`
+	Kind           string            `json:"kind"`
+	IntegrationURL string            `json:"integration_url"`
+	Headers        map[string]string `json:"headers"`
+	SMTP           SMTPConfig        `json:"smtp"`
+	Events         []EventType       `json:"events"`
+}
+
+// New returns the notifier described by config
+func New(config Config) (Notifier, error) {
+	switch config.Kind {
+	case "slack":
+		if len(config.IntegrationURL) == 0 {
+			return nil, certs.NewCertError("Slack notifier " + config.Name + " requires an integration URL")
+		}
+		return &SlackNotifier{url: config.IntegrationURL}, nil
+	case "webhook":
+		if len(config.IntegrationURL) == 0 {
+			return nil, certs.NewCertError("Webhook notifier " + config.Name + " requires an integration URL")
+		}
+		return &WebhookNotifier{url: config.IntegrationURL, headers: config.Headers}, nil
+	case "smtp":
+		return NewSMTPNotifier(config.SMTP)
+	default:
+		return nil, certs.NewCertError("Unrecognized notifier kind: " + config.Kind)
+	}
+}
+
+// EnvConfig returns the notifier set with the NOTIFIER_TYPE and WEBHOOK_URL environment
+// variables, if any
+func EnvConfig() []Config {
+	kind := os.Getenv("NOTIFIER_TYPE")
+	if len(kind) == 0 {
+		return nil
+	}
+	return []Config{{
+		Name:           kind,
+		Kind:           kind,
+		IntegrationURL: os.Getenv("WEBHOOK_URL"),
+	}}
+}
+
+type target struct {
+	name     string
+	notifier Notifier
+	events   []EventType
+}
+
+func (t target) wants(eventType EventType) bool {
+	if len(t.events) == 0 {
+		return true
+	}
+	for _, e := range t.events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// queued is an event waiting to be sent, along with the context of the reconcile that raised it
+type queued struct {
+	ctx   context.Context
+	event Event
+}
+
+// Dispatcher sends events to every configured notifier. Events are queued and sent in the
+// background, so a slow notifier never holds up a reconcile. Failures and expiry warnings that
+// repeat for the same object are only sent once per hour, so retries do not flood the
+// notifiers.
+type Dispatcher struct {
+	targets []target
+	queue   chan queued
+
+	mutex sync.Mutex
+	sent  map[string]time.Time
+	now   func() time.Time
+}
+
+// NewDispatcher builds the notifiers described by configs, falling back to the ones set in
+// the environment when there are none
+func NewDispatcher(configs []Config) (*Dispatcher, error) {
+	if len(configs) == 0 {
+		configs = EnvConfig()
+	}
+
+	d := &Dispatcher{
+		sent: map[string]time.Time{},
+		now:  time.Now,
+	}
+	for _, config := range configs {
+		n, err := New(config)
+		if err != nil {
+			return nil, err
+		}
+		log.Info("Configured notifier", "name", config.Name, "kind", config.Kind)
+		d.targets = append(d.targets, target{name: config.Name, notifier: n, events: config.Events})
+	}
+
+	if len(d.targets) > 0 {
+		d.queue = make(chan queued, queueSize)
+		go d.run()
+	}
+	return d, nil
+}
+
+// Notify queues event for the notifiers that want it and returns without waiting for it to be
+// sent. Delivery errors are logged rather than returned, a notification is never a reason to
+// retry a reconcile. Events are dropped while the queue is full.
+func (d *Dispatcher) Notify(ctx context.Context, event Event) {
+	if len(d.targets) == 0 || d.repeated(event) {
+		return
+	}
+
+	select {
+	case d.queue <- queued{ctx: ctx, event: event}:
+	default:
+		log.Info("Notification queue is full, dropping event", "type", event.Type, "kind", event.Kind, "namespace", event.Namespace, "name", event.Name)
+	}
+}
+
+// run sends the queued events one at a time, in the order they were raised
+func (d *Dispatcher) run() {
+	for item := range d.queue {
+		d.send(item.ctx, item.event)
+	}
+}
+
+// send delivers event to the notifiers that want it
+func (d *Dispatcher) send(ctx context.Context, event Event) {
+	for _, t := range d.targets {
+		if !t.wants(event.Type) {
+			continue
+		}
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		err := t.notifier.Notify(sendCtx, event)
+		cancel()
+		if err != nil {
+			log.Error(err, "Failed to send notification", "notifier", t.name, "type", event.Type)
+		}
+	}
+}
+
+// repeated reports whether a warning about the object of event was sent recently.
+// Issuing a certificate clears the warnings of the object.
+func (d *Dispatcher) repeated(event Event) bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	object := event.Kind + "/" + event.Namespace + "/" + event.Name
+	switch event.Type {
+	case EventFailed, EventExpiring:
+		key := object + "/" + string(event.Type)
+		now := d.now()
+		if last, ok := d.sent[key]; ok && now.Sub(last) < repeatInterval {
+			return true
+		}
+		d.sent[key] = now
+	default:
+		delete(d.sent, object+"/"+string(EventFailed))
+		delete(d.sent, object+"/"+string(EventExpiring))
+	}
+	return false
+}
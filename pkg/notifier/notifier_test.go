@@ -0,0 +1,259 @@
+package notifier
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testEvent = Event{
+	Type:      EventIssued,
+	Kind:      "Route",
+	Namespace: "test",
+	Name:      "example",
+	Hosts:     []string{"www.example.com"},
+	Expiry:    time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+}
+
+func TestSlackNotify(t *testing.T) {
+	// setup
+	var payload map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer server.Close()
+
+	n, err := New(Config{Name: "slack", Kind: "slack", IntegrationURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	err = n.Notify(context.TODO(), testEvent)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(payload["text"], "Route test/example (www.example.com) was issued") {
+		t.Fatal("unexpected message: " + payload["text"])
+	}
+}
+
+func TestWebhookNotify(t *testing.T) {
+	// setup
+	var event Event
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&event)
+	}))
+	defer server.Close()
+
+	n, err := New(Config{Name: "hook", Kind: "webhook", IntegrationURL: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	err = n.Notify(context.TODO(), testEvent)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Bearer token" {
+		t.Fatal("headers were not sent")
+	}
+	if event.Type != EventIssued || event.Name != "example" || !event.Expiry.Equal(testEvent.Expiry) {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+
+	// rejected notifications are errors
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	if err := n.Notify(context.TODO(), testEvent); err == nil {
+		t.Fatal("expected an error for a rejected notification")
+	}
+}
+
+func TestSMTPNotify(t *testing.T) {
+	// setup
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan []string, 1)
+	go serveSMTP(listener, received)
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	n, err := New(Config{Name: "mail", Kind: "smtp", SMTP: SMTPConfig{
+		Host: "127.0.0.1",
+		Port: port,
+		From: "cert-operator@example.com",
+		To:   []string{"ops@example.com"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	err = n.Notify(context.TODO(), testEvent)
+
+	// assert
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := <-received
+	mail := strings.Join(lines, "\n")
+	if !strings.Contains(mail, "MAIL FROM:<cert-operator@example.com>") || !strings.Contains(mail, "RCPT TO:<ops@example.com>") {
+		t.Fatal("unexpected envelope:\n" + mail)
+	}
+	if !strings.Contains(mail, "Subject: [cert-operator] Certificate issued for Route test/example") {
+		t.Fatal("unexpected message:\n" + mail)
+	}
+}
+
+func TestDispatcherHoldsBackRepeatedWarnings(t *testing.T) {
+	// setup
+	received := make(chan Event, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		json.NewDecoder(r.Body).Decode(&event)
+		received <- event
+	}))
+	defer server.Close()
+
+	d, err := NewDispatcher([]Config{{Name: "hook", Kind: "webhook", IntegrationURL: server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	d.now = func() time.Time { return now }
+
+	failed := func(message string) Event {
+		event := testEvent
+		event.Type = EventFailed
+		event.Message = message
+		return event
+	}
+
+	// act
+	d.Notify(context.TODO(), failed("first"))
+	d.Notify(context.TODO(), failed("repeated"))
+	now = now.Add(repeatInterval)
+	d.Notify(context.TODO(), failed("after interval"))
+	d.Notify(context.TODO(), testEvent)
+	d.Notify(context.TODO(), failed("after issue"))
+
+	// assert
+	// events are sent in order, a repeated failure that was not held back shows up second
+	for _, expected := range []string{"first", "after interval", "", "after issue"} {
+		select {
+		case event := <-received:
+			if event.Message != expected {
+				t.Fatalf("expected event %q, got %q", expected, event.Message)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event %q was not sent", expected)
+		}
+	}
+	select {
+	case event := <-received:
+		t.Fatalf("unexpected event %q", event.Message)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDispatcherNotifyDoesNotWait(t *testing.T) {
+	// setup
+	release := make(chan struct{})
+	delivered := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		delivered <- struct{}{}
+	}))
+	defer server.Close()
+
+	d, err := NewDispatcher([]Config{{Name: "hook", Kind: "webhook", IntegrationURL: server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// act
+	returned := make(chan struct{})
+	go func() {
+		d.Notify(context.TODO(), testEvent)
+		close(returned)
+	}()
+
+	// assert
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("Notify waited for the notifier")
+	}
+	close(release)
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not sent")
+	}
+}
+
+// serveSMTP accepts one connection and plays a mail server that accepts everything,
+// sending the commands and message it received to received
+func serveSMTP(listener net.Listener, received chan<- []string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var lines []string
+	r := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+	inData := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			break
+		}
+		line = strings.TrimRight(line, "\r\n")
+		lines = append(lines, line)
+
+		if inData {
+			if line == "." {
+				inData = false
+				reply("250 OK")
+			}
+			continue
+		}
+
+		switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); cmd {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "DATA":
+			inData = true
+			reply("354 End data with <CR><LF>.<CR><LF>")
+		case "QUIT":
+			reply("221 Bye")
+			received <- lines
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+	received <- lines
+}
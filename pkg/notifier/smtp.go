@@ -0,0 +1,108 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redhat-cop/cert-operator/pkg/certs"
+)
+
+// SMTPConfig describes the mail server events are sent through and who receives them.
+// Username and Password are only used when the server offers authentication.
+type SMTPConfig struct {
+	Host     string   `json:"host"`
+	Port     int      `json:"port"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+// SMTPNotifier mails events, using STARTTLS when the server supports it
+type SMTPNotifier struct {
+	config SMTPConfig
+}
+
+func NewSMTPNotifier(config SMTPConfig) (*SMTPNotifier, error) {
+	if len(config.Host) == 0 {
+		return nil, certs.NewCertError("SMTP notifier requires a host")
+	}
+	if len(config.From) == 0 || len(config.To) == 0 {
+		return nil, certs.NewCertError("SMTP notifier requires a sender and recipients")
+	}
+	if config.Port == 0 {
+		config.Port = 25
+	}
+	return &SMTPNotifier{config: config}, nil
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, event Event) error {
+	addr := net.JoinHostPort(n.config.Host, strconv.Itoa(n.config.Port))
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return certs.NewCertError("could not connect to mail server: " + err.Error())
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, n.config.Host)
+	if err != nil {
+		return certs.NewCertError("could not connect to mail server: " + err.Error())
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: n.config.Host}); err != nil {
+			return certs.NewCertError("could not start TLS with mail server: " + err.Error())
+		}
+	}
+	if ok, _ := c.Extension("AUTH"); ok && len(n.config.Username) > 0 {
+		if err := c.Auth(smtp.PlainAuth("", n.config.Username, n.config.Password, n.config.Host)); err != nil {
+			return certs.NewCertError("could not authenticate with mail server: " + err.Error())
+		}
+	}
+
+	if err := c.Mail(n.config.From); err != nil {
+		return certs.NewCertError("mail server rejected sender: " + err.Error())
+	}
+	for _, to := range n.config.To {
+		if err := c.Rcpt(to); err != nil {
+			return certs.NewCertError("mail server rejected recipient " + to + ": " + err.Error())
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return certs.NewCertError("could not send mail: " + err.Error())
+	}
+	if _, err := w.Write(n.message(event)); err != nil {
+		return certs.NewCertError("could not send mail: " + err.Error())
+	}
+	if err := w.Close(); err != nil {
+		return certs.NewCertError("could not send mail: " + err.Error())
+	}
+	return c.Quit()
+}
+
+// message returns the mail for event, headers included
+func (n *SMTPNotifier) message(event Event) []byte {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.config.To, ", "))
+	fmt.Fprintf(&msg, "Subject: [cert-operator] Certificate %s for %s %s/%s\r\n", event.Type, event.Kind, event.Namespace, event.Name)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(event.Text() + "\r\n")
+	return msg.Bytes()
+}
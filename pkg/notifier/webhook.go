@@ -0,0 +1,60 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/redhat-cop/cert-operator/pkg/certs"
+)
+
+// SlackNotifier posts events to a Slack incoming webhook
+type SlackNotifier struct {
+	url string
+}
+
+func (n *SlackNotifier) Notify(ctx context.Context, event Event) error {
+	return postJSON(ctx, n.url, nil, map[string]string{"text": event.Text()})
+}
+
+// WebhookNotifier posts events as JSON to a URL, with the configured headers
+type WebhookNotifier struct {
+	url     string
+	headers map[string]string
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, event Event) error {
+	return postJSON(ctx, n.url, n.headers, event)
+}
+
+func postJSON(ctx context.Context, url string, headers map[string]string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return certs.NewCertError("could not create notification request: " + err.Error())
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return certs.NewCertError("could not send notification: " + err.Error())
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return certs.NewCertError(fmt.Sprintf("notification was rejected with status %d", resp.StatusCode))
+	}
+	return nil
+}
@@ -72,9 +72,24 @@ func routePassthroughTest(t *testing.T, f *framework.Framework, ctx *framework.T
 		return err
 	}
 
-	exampleRoute := generateTLSRoute(routev1.TLSTerminationPassthrough, namespace, "oassthrough")
+	exampleRoute := generateTLSRoute(routev1.TLSTerminationPassthrough, namespace, "passthrough")
+	exampleRoute.ObjectMeta.Annotations["openshift.io/cert-ctl-passthrough-secret"] = "passthrough-backend-tls"
 
-	return testRoute(exampleRoute, "failed", t, f, ctx)
+	if err := testRoute(exampleRoute, "secured", t, f, ctx); err != nil {
+		return err
+	}
+
+	// Verify that the certificate was written to the secret the backend mounts
+	exampleSecret := &corev1.Secret{}
+	err = f.Client.Get(goctx.TODO(), types.NamespacedName{Name: "passthrough-backend-tls", Namespace: namespace}, exampleSecret)
+	if err != nil {
+		return err
+	}
+	assert.Equal(t, exampleSecret.Type, corev1.SecretTypeTLS, "invalid secret type")
+	assert.Contains(t, exampleSecret.Data, "tls.crt", "did not contain tls.crt")
+	assert.Contains(t, exampleSecret.Data, "tls.key", "did not contain tls.key")
+
+	return nil
 }
 
 func getNamespace(ctx *framework.TestCtx) (string, error) {